@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/encryption"
+)
+
+// decrypt decrypts an encrypted kubeconfig so tenants can verify their keys.
+func decrypt(args []string) int {
+	var identityFile, input, output string
+
+	flagSet := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flagSet.StringVar(&identityFile, "identity-file", "", "File with age X25519 identities or a PEM encoded RSA private key")
+	flagSet.StringVar(&input, "in", "-", "(optional) File with the encrypted kubeconfig, - reads from stdin")
+	flagSet.StringVar(&output, "out", "-", "(optional) File to write the decrypted kubeconfig to, - writes to stdout")
+	if err := flagSet.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+
+	if identityFile == "" {
+		flagSet.Usage()
+		fmt.Fprintln(os.Stderr, "missing identity file")
+		return 1
+	}
+
+	identityData, err := os.ReadFile(identityFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed reading identity file: %v\n", err)
+		return 1
+	}
+	identities, err := encryption.ParseIdentities(identityData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed parsing identities: %v\n", err)
+		return 1
+	}
+
+	var ciphertext []byte
+	if input == "-" {
+		ciphertext, err = io.ReadAll(os.Stdin)
+	} else {
+		ciphertext, err = os.ReadFile(input)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed reading encrypted kubeconfig: %v\n", err)
+		return 1
+	}
+
+	plaintext, err := encryption.Decrypt(ciphertext, identities)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed decrypting kubeconfig: %v\n", err)
+		return 1
+	}
+
+	if output == "-" {
+		_, err = os.Stdout.Write(plaintext)
+	} else {
+		err = os.WriteFile(output, plaintext, 0o600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed writing decrypted kubeconfig: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/generator"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/plan"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/sinks"
+	corev1 "k8s.io/api/core/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	diffOutputTable = "table"
+	diffOutputJSON  = "json"
+)
+
+// diff prints the changes the generator would make to the kubeconfig secrets.
+// Exits with 2 when there are changes.
+func diff(args []string) int {
+	var output string
+
+	flagSet := flag.NewFlagSet("diff", flag.ContinueOnError)
+	initGeneratorFlags(flagSet)
+	initRunFlags(flagSet)
+	initLogFlags(flagSet)
+	flagSet.StringVar(&output, "output", diffOutputTable, "(optional) Output format, one of: table, json")
+	if err := flagSet.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+
+	appLogger := logConfig.NewLogger("diff")
+
+	if output != diffOutputTable && output != diffOutputJSON {
+		appLogger.Error("Invalid configuration", "reason", fmt.Errorf("unsupported output format '%s'", output))
+		return 1
+	}
+
+	// Plans only model the kubernetes secret sink.
+	for _, name := range sinks.Names(appConfig) {
+		if name != sinks.SinkKubernetes {
+			appLogger.Error("Invalid configuration", "reason", fmt.Errorf("the diff command only supports the %s sink, got '%s'", sinks.SinkKubernetes, name))
+			return 1
+		}
+	}
+
+	// The plan never writes, make sure nothing called along the way does either.
+	appConfig.ReportOnly = true
+
+	if err := appConfig.Validate(); err != nil {
+		flagSet.Usage()
+		appLogger.Error("Invalid configuration", "reason", err)
+		return 1
+	}
+
+	opArgs, err := newOperationArgs(appLogger)
+	if err != nil { // Logging taken care of.
+		return 1
+	}
+
+	ctx := context.Background()
+	currentPlan := plan.New()
+	namespaces, err := targetNamespaces(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return 1
+	}
+	ctx, err = k8s.EnsureEncryptionRecipients(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return 1
+	}
+	for _, ns := range namespaces {
+		sourceSecret, tenantConfig, err := generator.GenerateProxyKubeConfigFromSA(ctx, ns, opArgs)
+		if err != nil { // Logging taken care of.
+			currentPlan.Add(&plan.Entry{
+				Namespace:  ns,
+				SecretName: opArgs.AppConfig().TenantSecretName(),
+				Action:     plan.ActionError,
+				Error:      err.Error(),
+			})
+			continue
+		}
+		currentPlan.Add(planNamespace(ctx, ns, opArgs, tenantConfig, sourceSecret))
+	}
+
+	if output == diffOutputJSON {
+		err = currentPlan.WriteJSON(os.Stdout)
+	} else {
+		err = currentPlan.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		appLogger.Error("Failed writing plan", "reason", err)
+		return 1
+	}
+
+	if currentPlan.HasChanges() {
+		return 2
+	}
+	return 0
+}
+
+// planNamespace computes the plan entry of a namespace, a failure is recorded in the entry.
+func planNamespace(ctx context.Context, ns string, opArgs k8s.OperationArgs, tenantConfig *clientcmdapi.Config, sourceSecret *corev1.Secret) *plan.Entry {
+	entry, err := k8s.PlanKubeConfigSecret(ctx, ns, opArgs, tenantConfig, sourceSecret)
+	if err != nil { // Logging taken care of.
+		return &plan.Entry{
+			Namespace:  ns,
+			SecretName: opArgs.AppConfig().TenantSecretName(),
+			Action:     plan.ActionError,
+			Error:      err.Error(),
+		}
+	}
+	opArgs.Logger().Info("Planned kubeconfig secret changes",
+		"namespace", ns,
+		"secret-name", entry.SecretName,
+		"action", entry.Action,
+		"changes", entry.Changes)
+	return entry
+}
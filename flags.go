@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+)
+
+var appConfig *configuration.Config
+var httpConfig *configuration.HttpConfig
+var logConfig *configuration.LogConfig
+var vaultConfig *configuration.VaultConfig
+var fileSinkConfig *configuration.FileSinkConfig
+var sealedSecretsConfig *configuration.SealedSecretsConfig
+var pushgatewayConfig *configuration.PushgatewayConfig
+
+// initGeneratorFlags registers the flags required to generate a kubeconfig.
+func initGeneratorFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&appConfig.ServiceAccountName, "serviceaccount", "", "The name of the service account for which to create the kubeconfig")
+	flagSet.StringVar(&appConfig.NamespaceFromCLI, "namespace", configuration.DefaultNamespace, "(optional) The namespace of the service account and where the kubeconfig secret will be created, ignored when selectors are in use")
+	flagSet.StringVar(&appConfig.Server, "server", "", "The server url of the kubeconfig where API requests will be sent")
+	flagSet.StringVar(&appConfig.ServerTLSSecretNamespace, "server-tls-secret-namespace", configuration.DefaultNamespace, "(optional) The namespace of the server TLS secret")
+	flagSet.StringVar(&appConfig.ServerTLSSecretName, "server-tls-secret-name", "", "The server TLS secret name")
+	flagSet.StringVar(&appConfig.ServerTLSSecretCAKey, "server-tls-secret-ca-key", configuration.DefaultTLSecretCAKey, "(optional) The CA key in the server TLS secret")
+}
+
+// initRunFlags registers the flags controlling how kubeconfigs are stored.
+func initRunFlags(flagSet *flag.FlagSet) {
+	flagSet.Var(&appConfig.TargetNamespaceSelector, "namespace-label-selector", "(optional) The namespace of the service account and where the kubeconfig secret will be created")
+	flagSet.Var(&appConfig.Sinks, "sink", "(optional) Where to store generated kubeconfigs, one of: kubernetes, vault, remote, file, sealed-secret; can be repeated; kubernetes when not set")
+	flagSet.StringVar(&appConfig.SourceClusterName, "source-cluster-name", "", "(optional) Name of the cluster the generator runs in, written to the proxy-kubeconfig-generator/source-cluster label of the generated secrets, required with the remote sink, which only prunes secrets carrying it")
+	flagSet.Var(&appConfig.RemoteClusters, "remote-cluster", "(optional) Remote cluster to mirror kubeconfig secrets to with the remote sink, in the name=<name>,kubeconfig=<path>[,context=<context>][,namespace=<template>] format; can be repeated")
+	flagSet.StringVar(&appConfig.KubeConfigSecretKey, "kubeconfig-secret-key", configuration.DefaultKubeConfigSecretKey, "(optional) The key of the kubeconfig in the secret that will be created")
+	flagSet.StringVar(&appConfig.SourceSecretRevisionLabel, "source-secret-revision-label", configuration.DefaultSourceSecretResourceVersionLabel, "(optional) Label of the target secret where the last know source secret resource version is stored")
+	flagSet.DurationVar(&appConfig.IterationInterval, "iteration-interval", configuration.DefaultIterationInterval, "(optional) How long to wait between iterations")
+	flagSet.Var(&appConfig.EncryptionRecipients, "encryption-recipient", "(optional) age X25519 recipient or SSH RSA public key to encrypt the kubeconfig to; can be repeated")
+	flagSet.StringVar(&appConfig.EncryptionRecipientsFile, "encryption-recipients-file", "", "(optional) File with age X25519 recipients, SSH RSA public keys or PEM encoded RSA public keys to encrypt the kubeconfig to")
+	flagSet.StringVar(&appConfig.EncryptionRecipientsSecretName, "encryption-recipients-secret-name", "", "(optional) Secret with age X25519 recipients, SSH RSA public keys or PEM encoded RSA public keys to encrypt the kubeconfig to")
+	flagSet.StringVar(&appConfig.EncryptionRecipientsSecretNamespace, "encryption-recipients-secret-namespace", configuration.DefaultNamespace, "(optional) The namespace of the encryption recipients secret")
+	flagSet.StringVar(&appConfig.EncryptedKubeConfigSecretKey, "encrypted-kubeconfig-secret-key", configuration.DefaultEncryptedKubeConfigSecretKey, "(optional) The key of the encrypted kubeconfig in the secret, used instead of the plain kubeconfig key when encryption is enabled")
+	flagSet.StringVar(&appConfig.FieldManager, "field-manager", configuration.DefaultFieldManager, "(optional) Field manager name used when applying secrets")
+	flagSet.BoolVar(&appConfig.ForceApply, "force-apply", true, "(optional) When set, take over ownership of conflicting fields when applying secrets, otherwise conflicting writes fail")
+	flagSet.IntVar(&appConfig.WriteRetrySteps, "write-retry-steps", configuration.DefaultWriteRetrySteps, "(optional) Maximum number of secret write attempts on transient errors")
+	flagSet.DurationVar(&appConfig.WriteRetryInitialBackoff, "write-retry-initial-backoff", configuration.DefaultWriteRetryInitialBackoff, "(optional) How long to wait before the first secret write retry, doubled with every retry")
+	flagSet.BoolVar(&appConfig.FluxSecretLayout, "flux-secret-layout", false, "(optional) When set, the kubeconfig is additionally stored under the key Flux reads by default")
+	flagSet.StringVar(&appConfig.FluxObjectLabelSelector, "flux-object-label-selector", "", "(optional) When set, Flux Kustomization and HelmRelease objects matching the selector are patched to reference the kubeconfig secret")
+	flagSet.StringVar(&appConfig.FluxKustomizationAPIVersion, "flux-kustomization-api-version", configuration.DefaultFluxKustomizationAPIVersion, "(optional) API version of the Flux Kustomization resource")
+	flagSet.StringVar(&appConfig.FluxHelmReleaseAPIVersion, "flux-helmrelease-api-version", configuration.DefaultFluxHelmReleaseAPIVersion, "(optional) API version of the Flux HelmRelease resource")
+	flagSet.BoolVar(&appConfig.ImmutableSecrets, "immutable-secrets", false, "(optional) When set, immutable secrets with a revision suffix are created instead of updating secrets in place, the current secret name is stored in a ConfigMap named like the secret")
+	flagSet.DurationVar(&appConfig.ImmutableSecretGracePeriod, "immutable-secret-grace-period", configuration.DefaultImmutableSecretGracePeriod, "(optional) How long to keep superseded immutable secrets before deleting them")
+	flagSet.BoolVar(&appConfig.EmitEvents, "emit-events", true, "(optional) When set, Kubernetes events are emitted on the tenant service account and the generated secret")
+	flagSet.IntVar(&appConfig.EventBurst, "event-burst", configuration.DefaultEventBurst, "(optional) Number of events emitted for an object before events of the object are rate limited")
+	flagSet.DurationVar(&appConfig.EventRefillInterval, "event-refill-interval", configuration.DefaultEventRefillInterval, "(optional) How often a rate limited object may emit another event")
+	flagSet.BoolVar(&appConfig.AdoptExisting, "adopt-existing", false, "(optional) When set, program takes over existing secrets not created by the generator")
+	flagSet.BoolVar(&appConfig.DisallowUpdates, "disallow-updates", false, "(optional) When set, program does not update existing secrets")
+	flagSet.DurationVar(&appConfig.ShutdownTimeout, "shutdown-timeout", configuration.DefaultShutdownTimeout, "(optional) How long the in-flight run is given to finish after SIGTERM or SIGINT, keep it below the pod termination grace period")
+	flagSet.BoolVar(&appConfig.Once, "once", false, "(optional) When set, program runs a single iteration without the metrics server and exits, 0 on success, 2 when some namespaces failed, 1 when all failed")
+	flagSet.BoolVar(&appConfig.ReportOnly, "report-only", false, "(optional) When set, program does not mutate anything, only logs what would have been done")
+}
+
+// initSinkFlags registers the flags of the optional sinks.
+func initSinkFlags(flagSet *flag.FlagSet) {
+	// Vault sink flags
+	flagSet.StringVar(&vaultConfig.Address, "vault-address", "", "Vault address, required with the vault sink")
+	flagSet.StringVar(&vaultConfig.Mount, "vault-mount", configuration.DefaultVaultMount, "(optional) Mount path of the Vault KV v2 secrets engine")
+	flagSet.StringVar(&vaultConfig.PathTemplate, "vault-path-template", configuration.DefaultVaultPathTemplate, "(optional) Template of the Vault KV path the kubeconfig is written to, supports .Namespace, .ServiceAccountName and .SecretName")
+	flagSet.StringVar(&vaultConfig.AuthMount, "vault-auth-mount", configuration.DefaultVaultAuthMount, "(optional) Mount path of the Vault Kubernetes auth method")
+	flagSet.StringVar(&vaultConfig.AuthRole, "vault-auth-role", "", "Vault Kubernetes auth role, required with the vault sink")
+	flagSet.StringVar(&vaultConfig.JWTPath, "vault-jwt-path", configuration.DefaultVaultJWTPath, "(optional) Path of the service account token used to log in to Vault")
+	flagSet.StringVar(&vaultConfig.CACertFile, "vault-ca-cert-file", "", "(optional) Path of the CA certificate used to verify the Vault server certificate")
+	flagSet.DurationVar(&vaultConfig.Timeout, "vault-timeout", time.Second*10, "(optional) Vault request timeout")
+	// File sink flags
+	flagSet.StringVar(&fileSinkConfig.PathTemplate, "file-sink-path-template", configuration.DefaultFileSinkPathTemplate, "(optional) Template of the path the kubeconfig file is written to, supports .Namespace, .ServiceAccountName and .SecretName")
+	flagSet.Var(&fileSinkConfig.FileMode, "file-sink-file-mode", "(optional) Octal mode of the kubeconfig files")
+	// Sealed secret sink flags
+	flagSet.StringVar(&sealedSecretsConfig.CertFile, "sealed-secrets-cert-file", "", "(optional) File with the sealed secrets controller certificate, mutually exclusive with --sealed-secrets-cert-secret-name")
+	flagSet.StringVar(&sealedSecretsConfig.CertSecretName, "sealed-secrets-cert-secret-name", "", "(optional) Secret with the sealed secrets controller certificate, mutually exclusive with --sealed-secrets-cert-file")
+	flagSet.StringVar(&sealedSecretsConfig.CertSecretNamespace, "sealed-secrets-cert-secret-namespace", configuration.DefaultSealedSecretsCertSecretNamespace, "(optional) The namespace of the sealed secrets controller certificate secret")
+	flagSet.StringVar(&sealedSecretsConfig.CertSecretKey, "sealed-secrets-cert-secret-key", configuration.DefaultSealedSecretsCertSecretKey, "(optional) The certificate key in the sealed secrets controller certificate secret")
+	flagSet.StringVar(&sealedSecretsConfig.Scope, "sealed-secrets-scope", configuration.DefaultSealedSecretsScope, "(optional) Scope of the sealed secrets, one of: strict, namespace-wide, cluster-wide")
+	flagSet.StringVar(&sealedSecretsConfig.OutputPathTemplate, "sealed-secrets-output-path-template", "", "(optional) When set, sealed secrets are written as YAML files to this path instead of the cluster, supports .Namespace, .ServiceAccountName and .SecretName")
+}
+
+// initPushFlags registers the flags of the Pushgateway used in one-shot mode.
+func initPushFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&pushgatewayConfig.URL, "pushgateway-url", "", "(optional) When set, metrics are pushed to this Pushgateway at the end of a one-shot run")
+	flagSet.StringVar(&pushgatewayConfig.Job, "pushgateway-job", configuration.DefaultPushgatewayJob, "(optional) Job name metrics are pushed under")
+	flagSet.Var(&pushgatewayConfig.Grouping, "pushgateway-grouping", "(optional) Grouping label in the name=value format; can be repeated")
+	flagSet.StringVar(&pushgatewayConfig.Username, "pushgateway-username", "", "(optional) Pushgateway basic auth username")
+	flagSet.StringVar(&pushgatewayConfig.PasswordFile, "pushgateway-password-file", "", "(optional) File with the Pushgateway basic auth password")
+	flagSet.StringVar(&pushgatewayConfig.CACertFile, "pushgateway-ca-cert-file", "", "(optional) Path of the CA certificate used to verify the Pushgateway server certificate")
+	flagSet.StringVar(&pushgatewayConfig.ClientCertFile, "pushgateway-client-cert-file", "", "(optional) Path of the client certificate presented to the Pushgateway")
+	flagSet.StringVar(&pushgatewayConfig.ClientKeyFile, "pushgateway-client-key-file", "", "(optional) Path of the client certificate key")
+	flagSet.BoolVar(&pushgatewayConfig.InsecureSkipVerify, "pushgateway-insecure-skip-verify", false, "(optional) When set, the Pushgateway server certificate is not verified")
+	flagSet.DurationVar(&pushgatewayConfig.Timeout, "pushgateway-timeout", configuration.DefaultPushgatewayTimeout, "(optional) Pushgateway request timeout")
+}
+
+func initLogFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&logConfig.LogLevel, "log-level", "info", "Log level")
+	flagSet.BoolVar(&logConfig.LogAsJSON, "log-as-json", false, "Log as JSON")
+	flagSet.BoolVar(&logConfig.LogColor, "log-color", false, "Log color")
+	flagSet.BoolVar(&logConfig.LogForceColor, "log-force-color", false, "Force log color output")
+}
+
+func initHTTPFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&httpConfig.MetricsBindHostPort, "metrics-server-bind-host-port", ":10000", "Host port to bind the metrics server on")
+	flagSet.BoolVar(&httpConfig.MetricsAppRevisionLabel, "metrics-app-revision-label", false, "(optional, deprecated) Add the app_revision label with the start time of the process to the generator metrics, will be removed in the next release")
+	flagSet.StringVar(&httpConfig.URIPathHealth, "uri-path-health", "/health", "URI path at which the health endpoint responds")
+	flagSet.StringVar(&httpConfig.URIPathMetrics, "uri-path-metrics", "/metrics", "URI path at which the metrics endpoint responds")
+	flagSet.StringVar(&httpConfig.URIPathStatus, "uri-path-status", "/status", "(optional) URI path at which the per namespace generation state is served as JSON or HTML, empty disables the endpoint")
+	flagSet.StringVar(&httpConfig.URIPathReconcile, "uri-path-reconcile", "/reconcile", "(optional) URI path at which runs can be triggered with POST, empty disables the endpoint")
+	flagSet.Var(&httpConfig.ReconcileAllowedGroups, "reconcile-allowed-group", "(optional) Group allowed to trigger runs, the reconcile endpoint is disabled when no groups or service accounts are allowed; can be repeated")
+	flagSet.Var(&httpConfig.ReconcileAllowedServiceAccounts, "reconcile-allowed-serviceaccount", "(optional) Service account allowed to trigger runs in the namespace/name format; can be repeated")
+	flagSet.StringVar(&httpConfig.URIPathLivez, "uri-path-livez", "/livez", "URI path at which the liveness endpoint responds")
+	flagSet.StringVar(&httpConfig.URIPathReadyz, "uri-path-readyz", "/readyz", "URI path at which the readiness endpoint responds")
+	flagSet.DurationVar(&httpConfig.LivenessMaxTickAge, "liveness-max-tick-age", configuration.DefaultLivenessMaxTickAge, "(optional) Liveness fails when the loop did not start a run for this long, keep it above the iteration interval plus the duration of a run")
+	flagSet.DurationVar(&httpConfig.ReadinessAPIServerTimeout, "readiness-api-server-timeout", configuration.DefaultReadinessAPIServerTimeout, "(optional) Timeout of the API server reachability check of the readiness endpoint")
+	flagSet.StringVar(&httpConfig.TLSCertFile, "metrics-server-tls-cert-file", "", "(optional) Certificate file the metrics server is served over TLS with, reloaded when it changes")
+	flagSet.StringVar(&httpConfig.TLSKeyFile, "metrics-server-tls-key-file", "", "(optional) Private key file of the metrics server certificate, reloaded when it changes")
+	flagSet.StringVar(&httpConfig.TLSClientCAFile, "metrics-server-tls-client-ca-file", "", "(optional) CA file client certificates are verified with, a client certificate is then required for the metrics endpoint unless -metrics-delegated-authz is set")
+	flagSet.BoolVar(&httpConfig.MetricsDelegatedAuthz, "metrics-delegated-authz", false, "(optional) Authenticate metrics requests with a bearer token using TokenReview and authorize them with SubjectAccessReview")
+	flagSet.StringVar(&httpConfig.URIPathPlan, "uri-path-plan", "/plan", "(optional) URI path at which the plan computed in report only mode is served as JSON, empty disables the endpoint")
+}
+
+func initConfigs() {
+	appConfig = &configuration.Config{
+		TargetNamespaceSelector: configuration.NamespaceSelectorLabels{
+			Values: []string{},
+		},
+	}
+	httpConfig = new(configuration.HttpConfig)
+	vaultConfig = new(configuration.VaultConfig)
+	sealedSecretsConfig = new(configuration.SealedSecretsConfig)
+	fileSinkConfig = &configuration.FileSinkConfig{
+		FileMode: configuration.DefaultFileSinkFileMode,
+	}
+	pushgatewayConfig = new(configuration.PushgatewayConfig)
+	logConfig = new(configuration.LogConfig)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/generator"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// redactedToken replaces the service account token in generated kubeconfigs
+// unless the token is explicitly requested.
+const redactedToken = "REDACTED"
+
+// generate prints the kubeconfig generated for a namespace without storing it.
+func generate(args []string) int {
+	var output string
+	var includeToken bool
+
+	flagSet := flag.NewFlagSet("generate", flag.ContinueOnError)
+	initGeneratorFlags(flagSet)
+	initLogFlags(flagSet)
+	flagSet.StringVar(&output, "o", "-", "(optional) File to write the kubeconfig to, - writes to stdout")
+	flagSet.BoolVar(&includeToken, "include-token", false, "(optional) When set, the service account token is included instead of being redacted")
+	if err := flagSet.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+
+	appLogger := logConfig.NewLogger("generate")
+
+	if err := appConfig.ValidateGenerator(); err != nil {
+		flagSet.Usage()
+		appLogger.Error("Invalid configuration", "reason", err)
+		return 1
+	}
+
+	opArgs, err := newOperationArgs(appLogger)
+	if err != nil { // Logging taken care of.
+		return 1
+	}
+
+	_, tenantConfig, err := generator.GenerateProxyKubeConfigFromSA(context.Background(), appConfig.NamespaceFromCLI, opArgs)
+	if err != nil { // Logging taken care of.
+		return 1
+	}
+
+	if !includeToken {
+		for _, authInfo := range tenantConfig.AuthInfos {
+			if authInfo.Token != "" {
+				authInfo.Token = redactedToken
+			}
+		}
+	}
+
+	configBuffer, err := clientcmd.Write(*tenantConfig)
+	if err != nil {
+		appLogger.Error("Failed serializing kubeconfig to buffer", "reason", err)
+		return 1
+	}
+
+	if output == "-" {
+		_, err = os.Stdout.Write(configBuffer)
+	} else {
+		err = os.WriteFile(output, configBuffer, 0o600)
+	}
+	if err != nil {
+		appLogger.Error("Failed writing kubeconfig", "output", output, "reason", err)
+		return 1
+	}
+
+	return 0
+}
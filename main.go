@@ -2,93 +2,262 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
-	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/auth"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/generator"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/health"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/plan"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/server"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/sinks"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/status"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/tracing"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/version"
 )
 
-var appConfig *configuration.Config
-var httpConfig *configuration.HttpConfig
-var logConfig *configuration.LogConfig
-
-func initFlags() {
-	// Main program flags
-	flag.StringVar(&appConfig.ServiceAccountName, "serviceaccount", "", "The name of the service account for which to create the kubeconfig")
-	flag.StringVar(&appConfig.NamespaceFromCLI, "namespace", configuration.DefaultNamespace, "(optional) The namespace of the service account and where the kubeconfig secret will be created, ignored when selectors are in use")
-	flag.Var(&appConfig.TargetNamespaceSelector, "namespace-label-selector", "(optional) The namespace of the service account and where the kubeconfig secret will be created")
-	flag.StringVar(&appConfig.Server, "server", "", "The server url of the kubeconfig where API requests will be sent")
-	flag.StringVar(&appConfig.ServerTLSSecretNamespace, "server-tls-secret-namespace", configuration.DefaultNamespace, "(optional) The namespace of the server TLS secret")
-	flag.StringVar(&appConfig.ServerTLSSecretName, "server-tls-secret-name", "", "The server TLS secret name")
-	flag.StringVar(&appConfig.ServerTLSSecretCAKey, "server-tls-secret-ca-key", configuration.DefaultTLSecretCAKey, "(optional) The CA key in the server TLS secret")
-	flag.StringVar(&appConfig.KubeConfigSecretKey, "kubeconfig-secret-key", configuration.DefaultKubeConfigSecretKey, "(optional) The key of the kubeconfig in the secret that will be created")
-	flag.StringVar(&appConfig.SourceSecretRevisionLabel, "source-secret-revision-label", configuration.DefaultSourceSecretResourceVersionLabel, "(optional) Label of the target secret where the last know source secret resource version is stored")
-	flag.DurationVar(&appConfig.IterationInterval, "iteration-interval", configuration.DefaultIterationInterval, "(optional) How long to wait between iterations")
-	flag.BoolVar(&appConfig.DisallowUpdates, "disallow-updates", false, "(optional) When set, program does not update existing secrets")
-	flag.BoolVar(&appConfig.ReportOnly, "report-only", false, "(optional) When set, program does not mutate anything, only logs what would have been done")
-	// Logging flags
-	flag.StringVar(&logConfig.LogLevel, "log-level", "info", "Log level")
-	flag.BoolVar(&logConfig.LogAsJSON, "log-as-json", false, "Log as JSON")
-	flag.BoolVar(&logConfig.LogColor, "log-color", false, "Log color")
-	flag.BoolVar(&logConfig.LogForceColor, "log-force-color", false, "Force log color output")
-	// HTTP flags
-	flag.StringVar(&httpConfig.MetricsBindHostPort, "metrics-server-bind-host-port", ":10000", "Host port to bind the metrics server on")
-	flag.StringVar(&httpConfig.URIPathHealth, "uri-path-health", "/health", "URI path at which the health endpoint responds")
-	flag.StringVar(&httpConfig.URIPathMetrics, "uri-path-metrics", "/metrics", "URI path at which the metrics endpoint responds")
-}
-
-func initConfigs() {
-	appConfig = &configuration.Config{
-		TargetNamespaceSelector: configuration.NamespaceSelectorLabels{
-			Values: []string{},
-		},
-	}
-	httpConfig = new(configuration.HttpConfig)
-	logConfig = new(configuration.LogConfig)
+const (
+	exitCodeSuccess        = 0
+	exitCodeFailure        = 1
+	exitCodePartialFailure = 2
+)
+
+// tracingShutdownTimeout is how long pending spans are flushed for on exit.
+const tracingShutdownTimeout = time.Second * 5
+
+// eventFlushTimeout is how long queued Kubernetes events are flushed for on exit.
+const eventFlushTimeout = time.Second * 5
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+func commandList() []command {
+	return []command{
+		{name: "run", description: "Generate kubeconfigs continuously, the default when no command is given", run: run},
+		{name: "diff", description: "Show the changes the generator would make to kubeconfig secrets", run: diff},
+		{name: "generate", description: "Print the kubeconfig generated for a namespace without storing it", run: generate},
+		{name: "decrypt", description: "Decrypt an encrypted kubeconfig", run: decrypt},
+		{name: "help", description: "Show this help", run: help},
+	}
 }
 
 func init() {
 	initConfigs()
-	initFlags()
 }
 
 func main() {
-	flag.Parse()
-	os.Exit(program())
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+func runCommand(args []string) int {
+	// Flags without a command run the generator, as before commands were introduced.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return run(args)
+	}
+	for _, cmd := range commandList() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n", args[0])
+	usage()
+	return 1
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commandList() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", filepath.Base(os.Args[0]))
+}
+
+func help(args []string) int {
+	usage()
+	return 0
+}
+
+// newOperationArgs builds the Kubernetes clients and returns operation arguments using them.
+func newOperationArgs(appLogger hclog.Logger) (k8s.OperationArgs, error) {
+	config, err := k8s.BuildKubernetesClientConfig(appLogger)
+	if err != nil {
+		appLogger.Error("Failed building client configuration", "reason", err)
+		return nil, err
+	}
+
+	k8s.InstrumentClientConfig(config, k8s.LocalCluster)
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		appLogger.Error("Failed building new Kubernetes client", "reason", err)
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		appLogger.Error("Failed building new Kubernetes dynamic client", "reason", err)
+		return nil, err
+	}
+
+	return k8s.NewDefaultOperationArgs(appConfig, clientset, dynamicClient, appLogger), nil
+}
+
+func run(args []string) int {
+	flagSet := flag.NewFlagSet("run", flag.ContinueOnError)
+	initGeneratorFlags(flagSet)
+	initRunFlags(flagSet)
+	initSinkFlags(flagSet)
+	initPushFlags(flagSet)
+	initLogFlags(flagSet)
+	initHTTPFlags(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	return program(flagSet)
 }
 
-func program() int {
+func program(flagSet *flag.FlagSet) int {
 
 	appLogger := logConfig.NewLogger("generator")
 
 	if err := appConfig.Validate(); err != nil {
-		flag.Usage()
+		flagSet.Usage()
 		appLogger.Error("Invalid configuration", "reason", err)
 		return 1
 	}
 
-	config, err := k8s.BuildKubernetesClientConfig(appLogger)
+	if err := httpConfig.Validate(); err != nil {
+		flagSet.Usage()
+		appLogger.Error("Invalid configuration", "reason", err)
+		return 1
+	}
+
+	if !appConfig.Once {
+		if err := httpConfig.ValidateLiveness(appConfig); err != nil {
+			flagSet.Usage()
+			appLogger.Error("Invalid configuration", "reason", err)
+			return 1
+		}
+	}
+
+	appLogger.Info("Starting generator",
+		"version", version.Version,
+		"commit", version.Commit,
+		"go-version", version.GoVersion())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), appLogger.Named("tracing"))
 	if err != nil {
-		appLogger.Error("Failed building client configuration", "reason", err)
+		appLogger.Error("Failed configuring tracing", "reason", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancelFunc()
+		if err := shutdownTracing(ctx); err != nil {
+			appLogger.Warn("Failed flushing traces", "reason", err)
+		}
+	}()
+
+	if httpConfig.MetricsAppRevisionLabel {
+		appLogger.Warn("The app_revision metrics label is deprecated and will be removed in the next release")
+		metrics.EnableAppRevisionLabel()
+	}
+
+	opArgs, err := newOperationArgs(appLogger)
+	if err != nil { // Logging taken care of.
 		return 1
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	if appConfig.EmitEvents {
+		eventRecorder, stopEventRecorder := k8s.NewEventRecorder(opArgs.ClientSet(), appConfig)
+		defer func() {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), eventFlushTimeout)
+			defer cancelFunc()
+			stopEventRecorder(ctx)
+		}()
+		opArgs = k8s.WithEventRecorder(opArgs, eventRecorder)
+	}
+
+	kubeConfigSinks, err := sinks.NewSinks(vaultConfig, fileSinkConfig, sealedSecretsConfig, opArgs)
 	if err != nil {
-		appLogger.Error("Failed building new Kubernetes client", "reason", err)
+		appLogger.Error("Failed configuring sinks", "reason", err)
 		return 1
 	}
 
+	planStore := plan.NewStore()
+	statusStore := status.NewStore()
+
+	if appConfig.Once {
+		var pusher *push.Pusher
+		if pushgatewayConfig.Enabled() {
+			pusher, err = metrics.NewPusher(pushgatewayConfig)
+			if err != nil {
+				appLogger.Error("Failed configuring pushgateway", "reason", err)
+				return 1
+			}
+		}
+		return programOnce(opArgs, kubeConfigSinks, planStore, statusStore, pusher)
+	}
+
 	serverRunner := server.NewDefaultRunner(appLogger.Named("server"))
+	if httpConfig.MetricsDelegatedAuthz {
+		authorizer := auth.NewSubjectAccessReviewAuthorizer(opArgs.ClientSet(), appLogger.Named("auth"))
+		serverRunner.WrapMetrics(authorizer.Wrap)
+	}
+	if httpConfig.URIPathPlan != "" {
+		serverRunner.Handle(httpConfig.URIPathPlan, planStore.Handler())
+	}
+	if httpConfig.URIPathStatus != "" {
+		serverRunner.Handle(httpConfig.URIPathStatus, statusStore.Handler())
+	}
+	runQueue := newRunQueue()
+	if httpConfig.URIPathReconcile != "" {
+		if len(httpConfig.ReconcileAllowedGroups.Values) == 0 && len(httpConfig.ReconcileAllowedServiceAccounts.Values) == 0 {
+			appLogger.Info("No reconcile groups or service accounts allowed, the reconcile endpoint is disabled")
+		} else {
+			authenticator, err := auth.NewTokenReviewAuthenticator(opArgs.ClientSet(),
+				httpConfig.ReconcileAllowedGroups.Values,
+				httpConfig.ReconcileAllowedServiceAccounts.Values,
+				appLogger.Named("auth"))
+			if err != nil {
+				appLogger.Error("Invalid configuration", "reason", err)
+				return 1
+			}
+			serverRunner.Handle(httpConfig.URIPathReconcile, authenticator.Wrap(reconcileHandler(runQueue, appLogger.Named("reconcile"))))
+		}
+	}
+	healthTracker := health.NewTracker()
+	serverRunner.Handle(httpConfig.URIPathLivez, health.Handler(
+		healthTracker.LoopCheck(httpConfig.LivenessMaxTickAge)))
+	serverRunner.Handle(httpConfig.URIPathReadyz, health.Handler(
+		healthTracker.FirstRunCheck(),
+		health.APIServerCheck(opArgs.ClientSet(), httpConfig.ReadinessAPIServerTimeout)))
 	status := serverRunner.Start(httpConfig)
 	select {
 	case <-status.OnStarted():
@@ -97,91 +266,341 @@ func program() int {
 		return 1
 	}
 
-	opArgs := k8s.NewDefaultOperationArgs(appConfig, clientset, appLogger)
-
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	exitCtx, exitCtxCancelFunc := context.WithCancel(context.Background())
-
 	if len(appConfig.TargetNamespaceSelector.Values) > 0 {
 		appLogger.Info("Namespace selectors defined, going to use namespace discovery instead of the --namespace value")
 	}
 
+	// Runs use their own context so that a stop signal does not abort the in-flight run.
+	runCtx, runCtxCancelFunc := context.WithCancel(context.Background())
+	defer runCtxCancelFunc()
+
+	stopc := make(chan os.Signal, 1)
+	signal.Notify(stopc, os.Interrupt, syscall.SIGTERM)
+
+	var stopSignal os.Signal
+	var lastErrs map[string]error
+	runInFlight := false
+
 	// Execute at least once:
-	if errs := runOnce(exitCtx, opArgs); len(errs) > 0 {
-		for ns, err := range errs {
-			appLogger.Error("kubeconfig generator failed to generate", "namespace", ns, "reason", err)
+	runQueue.enqueue()
+
+	for {
+		run := runQueue.take()
+		runDone := make(chan map[string]error, 1)
+		runInFlight = true
+		healthTracker.Tick()
+		go func() {
+			processed, errs, err := runOnce(runCtx, opArgs, kubeConfigSinks, planStore, statusStore, run.namespaces)
+			if err != nil { // Logging taken care of.
+				run.abort(err)
+				runDone <- nil
+				return
+			}
+			healthTracker.RunCompleted()
+			run.complete(processed, errs)
+			runDone <- errs
+		}()
+
+		select {
+		case lastErrs = <-runDone:
+			runInFlight = false
+			logRunErrors(appLogger, lastErrs)
+		case stopSignal = <-stopc:
 		}
-	}
 
-	go func() {
-		for {
+		if stopSignal == nil {
 			select {
 			case <-time.After(appConfig.IterationInterval):
-				if errs := runOnce(exitCtx, opArgs); len(errs) > 0 {
-					for ns, err := range errs {
-						appLogger.Error("kubeconfig generator failed to generate", "namespace", ns, "reason", err)
-					}
+				runQueue.enqueue()
+				continue
+			case <-runQueue.wakeup:
+				// Triggered run, the periodic schedule restarts after it.
+				continue
+			case stopSignal = <-stopc:
+			}
+		}
+
+		appLogger.Info("stop signal received, going to stop",
+			"signal", stopSignal.String(),
+			"run-in-flight", runInFlight,
+			"shutdown-timeout", appConfig.ShutdownTimeout)
+
+		shutdownCtx, shutdownCtxCancelFunc := context.WithTimeout(context.Background(), appConfig.ShutdownTimeout)
+		defer shutdownCtxCancelFunc()
+
+		runInterrupted := false
+		if runInFlight {
+			select {
+			case lastErrs = <-runDone:
+			case <-shutdownCtx.Done():
+				// Abort the remaining work, runOnce returns as soon as the pending requests fail.
+				runInterrupted = true
+				runCtxCancelFunc()
+				lastErrs = <-runDone
+			}
+			logRunErrors(appLogger, lastErrs)
+		}
+
+		// Release callers waiting for a run which will not start.
+		runQueue.close(errShuttingDown)
+
+		serverShutdownErr := serverRunner.Shutdown(shutdownCtx)
+
+		notProcessed := []string{}
+		if runInterrupted {
+			for ns, err := range lastErrs {
+				if errors.Is(err, context.Canceled) {
+					notProcessed = append(notProcessed, ns)
 				}
-			case <-exitCtx.Done():
-				appLogger.Info("stopping run loop")
-				wg.Done()
-				return
 			}
+			sort.Strings(notProcessed)
 		}
-	}()
 
-	sigintc := make(chan os.Signal, 1)
-	signal.Notify(sigintc, os.Interrupt)
+		appLogger.Info("shutdown",
+			"signal", stopSignal.String(),
+			"run-in-flight", runInFlight,
+			"run-interrupted", runInterrupted,
+			"namespaces-not-processed", notProcessed,
+			"server-shutdown-error", serverShutdownErr)
 
-	go func() {
-		<-sigintc
-		appLogger.Info("sigint handled, going to stop")
-		exitCtxCancelFunc()
-	}()
+		return 0
+	}
+}
 
-	wg.Wait()
+func logRunErrors(appLogger hclog.Logger, errs map[string]error) {
+	for ns, err := range errs {
+		appLogger.Error("kubeconfig generator failed to generate", "namespace", ns, "reason", err)
+	}
+}
 
-	appLogger.Info("all done")
+// targetNamespaces returns the namespaces to generate kubeconfigs for,
+// an error when the namespaces could not be discovered.
+func targetNamespaces(ctx context.Context, opArgs k8s.OperationArgs) ([]string, error) {
+	if len(opArgs.AppConfig().TargetNamespaceSelector.Values) == 0 {
+		return []string{opArgs.AppConfig().NamespaceFromCLI}, nil
+	}
+	namespaceList, err := k8s.FindNamespaces(ctx, opArgs)
+	if err != nil {
+		opArgs.Logger().Error("Failed loading namespace list", "reason", err)
+		return nil, err
+	}
+	namespaces := []string{}
+	for _, ns := range namespaceList.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	opArgs.Logger().Info("Discovered namespaces",
+		"number-of-namespaces", len(namespaces),
+		"namespaces", namespaces,
+		"selectors", opArgs.AppConfig().TargetNamespaceSelector.Values)
+	return namespaces, nil
+}
 
-	return 0
+// programOnce runs a single iteration and pushes the metrics when a pusher is given.
+// The exit code tells full success, partial failure and total failure apart.
+func programOnce(opArgs k8s.OperationArgs, kubeConfigSinks []sinks.Sink, planStore *plan.Store, statusStore *status.Store, pusher *push.Pusher) int {
+	processed, errs, err := runOnce(context.Background(), opArgs, kubeConfigSinks, planStore, statusStore, nil)
+	numberOfNamespaces := len(processed)
+	logRunErrors(opArgs.Logger(), errs)
+	if pusher != nil {
+		// A failed push does not change the outcome of the run.
+		if err := pusher.Push(); err != nil {
+			opArgs.Logger().Error("Failed pushing metrics to the pushgateway",
+				"pushgateway-url", pushgatewayConfig.URL,
+				"reason", err)
+		} else {
+			opArgs.Logger().Info("Metrics pushed to the pushgateway",
+				"pushgateway-url", pushgatewayConfig.URL,
+				"job", pushgatewayConfig.Job)
+		}
+	}
+	switch {
+	case err != nil:
+		opArgs.Logger().Error("namespaces could not be discovered, nothing done", "reason", err)
+		return exitCodeFailure
+	case len(errs) == 0:
+		opArgs.Logger().Info("all done", "number-of-namespaces", numberOfNamespaces)
+		return exitCodeSuccess
+	case len(errs) < numberOfNamespaces:
+		opArgs.Logger().Warn("done with failures",
+			"number-of-namespaces", numberOfNamespaces,
+			"number-of-failed-namespaces", len(errs))
+		return exitCodePartialFailure
+	}
+	opArgs.Logger().Error("all namespaces failed", "number-of-namespaces", numberOfNamespaces)
+	return exitCodeFailure
 }
 
-func runOnce(ctx context.Context, opArgs k8s.OperationArgs) map[string]error {
+// runOnce reconciles the target namespaces, limited to the given ones when only is not nil.
+// It returns the processed namespaces and the errors of the failed ones, or an error
+// when the namespaces or the encryption recipients could not be resolved and nothing was processed.
+func runOnce(ctx context.Context, opArgs k8s.OperationArgs, kubeConfigSinks []sinks.Sink, planStore *plan.Store, statusStore *status.Store, only map[string]struct{}) ([]string, map[string]error, error) {
+	start := time.Now()
+	defer func() {
+		metrics.RecordRunDuration(time.Since(start).Seconds())
+	}()
+
+	ctx, span := tracing.Tracer().Start(ctx, "runOnce")
+	defer span.End()
+	opArgs = k8s.WithTraceLogger(ctx, opArgs)
+
+	namespaces, err := targetNamespaces(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		metrics.RecordRunCount()
+		tracing.RecordError(span, err)
+		return nil, nil, err
+	}
+
+	// The recipients are resolved once per run in the local cluster, also for the remote sink.
+	encryptionRecipients, err := k8s.ResolveEncryptionRecipients(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		metrics.RecordRunCount()
+		tracing.RecordError(span, err)
+		return nil, nil, err
+	}
+	ctx = k8s.WithEncryptionRecipients(ctx, encryptionRecipients)
+
 	errors := map[string]error{}
-	namespaces := []string{opArgs.AppConfig().NamespaceFromCLI}
-	if len(appConfig.TargetNamespaceSelector.Values) > 0 {
-		namespaceList, err := k8s.FindNamespaces(ctx, opArgs)
-		if err != nil {
-			opArgs.Logger().Error("Failed loading namespace list", "reason", err)
-		} else {
-			namespaces = []string{}
-			for _, ns := range namespaceList.Items {
-				namespaces = append(namespaces, ns.Name)
+	namespacesKnown := true
+
+	if only != nil {
+		targets := map[string]struct{}{}
+		for _, ns := range namespaces {
+			targets[ns] = struct{}{}
+		}
+		namespaces = []string{}
+		for ns := range only {
+			if _, ok := targets[ns]; !ok {
+				errors[ns] = fmt.Errorf("namespace '%s' is not a target namespace", ns)
+				continue
 			}
-			opArgs.Logger().Info("Discovered namespaces",
-				"number-of-namespaces", len(namespaces),
-				"namespaces", namespaces,
-				"selectors", appConfig.TargetNamespaceSelector.Values)
+			namespaces = append(namespaces, ns)
 		}
+		sort.Strings(namespaces)
+		// A partial run must not remove the kubeconfigs of the other namespaces.
+		namespacesKnown = false
 	}
 
-	metrics.RecordNamespaceCount(float64(len(namespaces)))
+	// Plans only model the kubernetes secret sink.
+	var currentPlan *plan.Plan
+	if opArgs.AppConfig().ReportOnly && hasKubernetesSink(kubeConfigSinks) {
+		currentPlan = plan.New()
+	}
+
+	if only == nil {
+		metrics.RecordNamespaceCount(float64(len(namespaces)))
+	}
 
 	for _, ns := range namespaces {
-		sourceSecret, tenantConfig, err := generator.GenerateProxyKubeConfigFromSA(ctx, ns, opArgs)
-		if err != nil { // Logging taken care of.
-			errors[ns] = err
+		if ctx.Err() != nil {
+			errors[ns] = fmt.Errorf("namespace not processed: %w", ctx.Err())
 			continue
 		}
-		err = k8s.CreateOrUpdateKubeConfigSecret(ctx, ns, opArgs, tenantConfig, sourceSecret)
-		if err != nil { // Logging taken care of.
+		if err := reconcileNamespace(ctx, ns, opArgs, kubeConfigSinks, currentPlan, statusStore); err != nil {
 			errors[ns] = err
-			continue
 		}
 	}
 
+	// Only prune when the list of namespaces is known to be complete.
+	if namespacesKnown {
+		statusStore.Retain(namespaces)
+		metrics.RetainFreshness(namespaces)
+		for _, sink := range kubeConfigSinks {
+			if pruner, ok := sink.(sinks.Pruner); ok {
+				if err := pruner.Prune(ctx, namespaces); err != nil {
+					opArgs.Logger().Error("Failed pruning kubeconfigs",
+						"sink", sink.Name(),
+						"reason", err)
+				}
+			}
+		}
+	}
+
+	if currentPlan != nil && only == nil {
+		planStore.Set(currentPlan)
+	}
+
 	metrics.RecordRunCount()
 
-	return errors
+	span.SetAttributes(
+		attribute.Int("generator.namespaces", len(namespaces)),
+		attribute.Int("generator.namespaces.failed", len(errors)))
+	if len(errors) > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d namespaces failed", len(errors)))
+	}
+
+	return namespaces, errors, nil
+}
+
+// hasKubernetesSink returns true when the kubernetes secret sink is one of the sinks.
+func hasKubernetesSink(kubeConfigSinks []sinks.Sink) bool {
+	for _, sink := range kubeConfigSinks {
+		if sink.Name() == sinks.SinkKubernetes {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileNamespace generates the kubeconfig of the namespace and stores it in every sink,
+// returns the error of the generation or of the first failed sink.
+func reconcileNamespace(ctx context.Context, ns string, opArgs k8s.OperationArgs, kubeConfigSinks []sinks.Sink, currentPlan *plan.Plan, statusStore *status.Store) error {
+	start := time.Now()
+	defer func() {
+		metrics.RecordNamespaceDuration(time.Since(start).Seconds())
+	}()
+
+	ctx, span := tracing.Tracer().Start(ctx, "reconcileNamespace",
+		trace.WithAttributes(attribute.String("k8s.namespace.name", ns)))
+	defer span.End()
+
+	sourceSecret, tenantConfig, err := generator.GenerateProxyKubeConfigFromSA(ctx, ns, opArgs)
+	if err != nil { // Logging taken care of.
+		for _, sink := range kubeConfigSinks {
+			statusStore.RecordFailure(sink.Name(), ns, err)
+			k8s.RecordResult(sink.Name(), ns, k8s.ResultFailed, err)
+		}
+		if currentPlan != nil {
+			currentPlan.Add(&plan.Entry{
+				Namespace:  ns,
+				SecretName: opArgs.AppConfig().TenantSecretName(),
+				Action:     plan.ActionError,
+				Error:      err.Error(),
+			})
+		}
+		tracing.RecordError(span, err)
+		return err
+	}
+	if currentPlan != nil {
+		currentPlan.Add(planNamespace(ctx, ns, opArgs, tenantConfig, sourceSecret))
+	}
+	var firstErr error
+	for _, sink := range kubeConfigSinks {
+		result, err := storeKubeConfig(ctx, sink, ns, tenantConfig, sourceSecret)
+		k8s.RecordResult(sink.Name(), ns, result, err)
+		if err != nil { // Logging taken care of.
+			statusStore.RecordFailure(sink.Name(), ns, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("sink %s: %w", sink.Name(), err)
+			}
+			continue
+		}
+		statusStore.RecordSuccess(sink.Name(), ns, k8s.SourceResourceRevision(sourceSecret), tenantConfig, result)
+		metrics.RecordFreshness(opArgs.AppConfig(), sink.Name(), ns, k8s.SourceResourceRevision(sourceSecret), status.CredentialExpiry(tenantConfig))
+	}
+	tracing.RecordError(span, firstErr)
+	return firstErr
+}
+
+// storeKubeConfig stores the kubeconfig in the sink in a span of its own.
+func storeKubeConfig(ctx context.Context, sink sinks.Sink, ns string, tenantConfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Store",
+		trace.WithAttributes(
+			attribute.String("k8s.namespace.name", ns),
+			attribute.String("generator.sink", sink.Name())))
+	defer span.End()
+
+	result, err := sink.Store(ctx, ns, tenantConfig, sourceSecret)
+	span.SetAttributes(attribute.String("generator.result", string(result)))
+	tracing.RecordError(span, err)
+	return result, err
 }
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// allowedTTL is how long an allowed subject access review is cached for.
+	allowedTTL = time.Minute * 5
+	// deniedTTL is how long a denied subject access review is cached for.
+	deniedTTL = time.Second * 30
+)
+
+// SubjectAccessReviewAuthorizer delegates authentication and authorization to Kubernetes.
+// Bearer tokens are authenticated with the TokenReview API, the user is then authorized
+// with a SubjectAccessReview for the non-resource URL of the request, the way the API
+// server authorizes /metrics. Review results are cached by token hash, path and verb.
+type SubjectAccessReviewAuthorizer struct {
+	clientSet kubernetes.Interface
+	reviewer  *tokenReviewer
+	cache     *cache.LRUExpireCache
+	logger    hclog.Logger
+}
+
+// NewSubjectAccessReviewAuthorizer returns a new delegating authorizer.
+func NewSubjectAccessReviewAuthorizer(clientSet kubernetes.Interface, logger hclog.Logger) *SubjectAccessReviewAuthorizer {
+	return &SubjectAccessReviewAuthorizer{
+		clientSet: clientSet,
+		reviewer:  newTokenReviewer(clientSet, logger),
+		cache:     cache.NewLRUExpireCache(reviewCacheSize),
+		logger:    logger,
+	}
+}
+
+// subjectAccessReviewStatus is the cached result of a subject access review.
+type subjectAccessReviewStatus struct {
+	allowed bool
+	reason  string
+}
+
+// Wrap returns a handler serving only requests of users allowed to access the request path.
+func (a *SubjectAccessReviewAuthorizer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := a.reviewer.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		verb := strings.ToLower(r.Method)
+		status, err := a.review(r, user, verb)
+		if err != nil {
+			a.logger.Error("Failed reviewing subject access", "reason", err)
+			http.Error(w, "subject access review failed", http.StatusInternalServerError)
+			return
+		}
+		if !status.allowed {
+			a.logger.Warn("Rejected request of a user not authorized",
+				"path", r.URL.Path,
+				"verb", verb,
+				"user", user.Username,
+				"reason", status.reason)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		a.logger.Debug("Request authorized",
+			"path", r.URL.Path,
+			"user", user.Username)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// review returns the cached subject access review of the request or reviews the access.
+// Failed reviews are not cached.
+func (a *SubjectAccessReviewAuthorizer) review(r *http.Request, user authenticationv1.UserInfo, verb string) (*subjectAccessReviewStatus, error) {
+	// The user is authenticated from the token, the token hash identifies the user.
+	key := strings.Join([]string{tokenHash(bearerToken(r)), verb, r.URL.Path}, "\x00")
+	if cached, ok := a.cache.Get(key); ok {
+		return cached.(*subjectAccessReviewStatus), nil
+	}
+
+	review, err := a.clientSet.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  subjectAccessReviewExtra(user.Extra),
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: verb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &subjectAccessReviewStatus{
+		allowed: review.Status.Allowed,
+		reason:  review.Status.Reason,
+	}
+	ttl := deniedTTL
+	if status.allowed {
+		ttl = allowedTTL
+	}
+	a.cache.Add(key, status, ttl)
+	return status, nil
+}
+
+func subjectAccessReviewExtra(extra map[string]authenticationv1.ExtraValue) map[string]authorizationv1.ExtraValue {
+	if extra == nil {
+		return nil
+	}
+	result := make(map[string]authorizationv1.ExtraValue, len(extra))
+	for key, value := range extra {
+		result[key] = authorizationv1.ExtraValue(value)
+	}
+	return result
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// reviewCacheSize is the maximum number of cached review results.
+	reviewCacheSize = 4096
+	// authenticatedTTL is how long a successful token review is cached for.
+	authenticatedTTL = time.Minute * 2
+	// unauthenticatedTTL is how long a failed token review is cached for.
+	unauthenticatedTTL = time.Second * 30
+)
+
+// TokenReviewAuthenticator authenticates bearer tokens with the Kubernetes TokenReview API
+// and allows users in the allowed groups and the allowed service accounts.
+type TokenReviewAuthenticator struct {
+	reviewer               *tokenReviewer
+	allowedGroups          map[string]struct{}
+	allowedServiceAccounts map[string]struct{}
+	logger                 hclog.Logger
+}
+
+// NewTokenReviewAuthenticator returns an authenticator allowing the groups and the service accounts,
+// service accounts are given in the namespace/name format.
+func NewTokenReviewAuthenticator(clientSet kubernetes.Interface, allowedGroups, allowedServiceAccounts []string, logger hclog.Logger) (*TokenReviewAuthenticator, error) {
+	authenticator := &TokenReviewAuthenticator{
+		reviewer:               newTokenReviewer(clientSet, logger),
+		allowedGroups:          map[string]struct{}{},
+		allowedServiceAccounts: map[string]struct{}{},
+		logger:                 logger,
+	}
+	for _, group := range allowedGroups {
+		authenticator.allowedGroups[group] = struct{}{}
+	}
+	for _, value := range allowedServiceAccounts {
+		parts := strings.Split(value, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid service account '%s', expected namespace/name", value)
+		}
+		authenticator.allowedServiceAccounts[fmt.Sprintf("system:serviceaccount:%s:%s", parts[0], parts[1])] = struct{}{}
+	}
+	return authenticator, nil
+}
+
+// Wrap returns a handler serving only requests with a valid bearer token of an allowed user.
+func (a *TokenReviewAuthenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := a.reviewer.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !a.allowed(user) {
+			a.logger.Warn("Rejected request of a user not allowed",
+				"path", r.URL.Path,
+				"user", user.Username,
+				"groups", user.Groups)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		a.logger.Debug("Request authenticated",
+			"path", r.URL.Path,
+			"user", user.Username)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *TokenReviewAuthenticator) allowed(user authenticationv1.UserInfo) bool {
+	if _, ok := a.allowedServiceAccounts[user.Username]; ok {
+		return true
+	}
+	for _, group := range user.Groups {
+		if _, ok := a.allowedGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenReviewer authenticates bearer tokens with the TokenReview API.
+// Review results are cached by token hash, the way kube-rbac-proxy does,
+// so that frequent requests like metrics scrapes don't create a review each.
+type tokenReviewer struct {
+	clientSet kubernetes.Interface
+	cache     *cache.LRUExpireCache
+	logger    hclog.Logger
+}
+
+func newTokenReviewer(clientSet kubernetes.Interface, logger hclog.Logger) *tokenReviewer {
+	return &tokenReviewer{
+		clientSet: clientSet,
+		cache:     cache.NewLRUExpireCache(reviewCacheSize),
+		logger:    logger,
+	}
+}
+
+// tokenReviewStatus is the cached result of a token review.
+type tokenReviewStatus struct {
+	authenticated bool
+	user          authenticationv1.UserInfo
+	reason        string
+}
+
+// authenticate reviews the bearer token of the request and returns the user,
+// the error response is written when the request is not authenticated.
+func (t *tokenReviewer) authenticate(w http.ResponseWriter, r *http.Request) (authenticationv1.UserInfo, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return authenticationv1.UserInfo{}, false
+	}
+
+	status, err := t.review(r, token)
+	if err != nil {
+		t.logger.Error("Failed reviewing token", "reason", err)
+		http.Error(w, "token review failed", http.StatusInternalServerError)
+		return authenticationv1.UserInfo{}, false
+	}
+	if !status.authenticated {
+		t.logger.Warn("Rejected unauthenticated request",
+			"path", r.URL.Path,
+			"reason", status.reason)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return authenticationv1.UserInfo{}, false
+	}
+	return status.user, true
+}
+
+// review returns the cached review of the token or reviews the token.
+// Failed reviews are not cached.
+func (t *tokenReviewer) review(r *http.Request, token string) (*tokenReviewStatus, error) {
+	key := tokenHash(token)
+	if cached, ok := t.cache.Get(key); ok {
+		return cached.(*tokenReviewStatus), nil
+	}
+
+	review, err := t.clientSet.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &tokenReviewStatus{
+		authenticated: review.Status.Authenticated,
+		user:          review.Status.User,
+		reason:        review.Status.Error,
+	}
+	ttl := unauthenticatedTTL
+	if status.authenticated {
+		ttl = authenticatedTTL
+	}
+	t.cache.Add(key, status, ttl)
+	return status, nil
+}
+
+// tokenHash returns the hash the token is cached by, tokens are not kept in memory.
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[0:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
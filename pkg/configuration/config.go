@@ -2,9 +2,12 @@ package configuration
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var appRevisionUtc = ""
@@ -14,6 +17,8 @@ func init() {
 }
 
 // AppRevision returns global app revision (ID of the run).
+//
+// Deprecated: only used by the app_revision metrics label kept for compatibility.
 func AppRevision() string {
 	return appRevisionUtc
 }
@@ -29,9 +34,76 @@ const (
 	DefaultSourceSecretResourceVersionLabel = "proxy-kubeconfig-generator/last-known-source-resource-version"
 	// DefaultIterationInterval is the default interval between individual iterations.
 	DefaultIterationInterval = time.Second * 60
+	// DefaultShutdownTimeout is the default time the in-flight run is given to finish on shutdown.
+	DefaultShutdownTimeout = time.Second * 25
+	// DefaultLivenessMaxTickAge is the default maximum time since the loop last started a run before liveness fails.
+	DefaultLivenessMaxTickAge = time.Minute * 10
+	// DefaultReadinessAPIServerTimeout is the default timeout of the API server readiness check.
+	DefaultReadinessAPIServerTimeout = time.Second * 5
+	// DefaultFluxKustomizationAPIVersion is the default API version of the Flux Kustomization resource.
+	DefaultFluxKustomizationAPIVersion = "v1beta2"
+	// DefaultFluxHelmReleaseAPIVersion is the default API version of the Flux HelmRelease resource.
+	DefaultFluxHelmReleaseAPIVersion = "v2beta1"
+	// DefaultEncryptedKubeConfigSecretKey is the default key of the encrypted kubeconfig in the secret.
+	DefaultEncryptedKubeConfigSecretKey = "kubeconfig.age"
+	// DefaultFieldManager is the default field manager used for server-side apply.
+	DefaultFieldManager = "proxy-kubeconfig-generator"
+	// DefaultWriteRetrySteps is the default maximum number of secret write attempts.
+	DefaultWriteRetrySteps = 5
+	// DefaultWriteRetryInitialBackoff is the default wait time before the first secret write retry.
+	DefaultWriteRetryInitialBackoff = time.Millisecond * 100
+	// DefaultImmutableSecretGracePeriod is the default time a superseded immutable secret is kept for.
+	DefaultImmutableSecretGracePeriod = time.Minute * 10
+	// DefaultEventBurst is the default number of events emitted for an object before rate limiting kicks in.
+	DefaultEventBurst = 5
+	// DefaultEventRefillInterval is the default interval at which a rate limited object may emit another event.
+	DefaultEventRefillInterval = time.Minute * 10
+	// ImmutableSecretGroupLabel is the label grouping immutable secrets generated for the same tenant secret name.
+	ImmutableSecretGroupLabel = "proxy-kubeconfig-generator/tenant-secret"
+	// ImmutableSecretSupersededAnnotation is the annotation holding the time an immutable secret was superseded at.
+	ImmutableSecretSupersededAnnotation = "proxy-kubeconfig-generator/superseded-at"
+	// ImmutableSecretAliasKey is the key of the alias ConfigMap holding the name of the current secret.
+	ImmutableSecretAliasKey = "secretName"
+	// DefaultVaultMount is the default mount path of the Vault KV v2 secrets engine.
+	DefaultVaultMount = "secret"
+	// DefaultVaultPathTemplate is the default template of the Vault KV path the kubeconfig is written to.
+	DefaultVaultPathTemplate = "proxy-kubeconfig-generator/{{ .Namespace }}/{{ .ServiceAccountName }}"
+	// DefaultVaultAuthMount is the default mount path of the Vault Kubernetes auth method.
+	DefaultVaultAuthMount = "kubernetes"
+	// DefaultVaultJWTPath is the default path of the service account token used to log in to Vault.
+	DefaultVaultJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// DefaultFileSinkPathTemplate is the default template of the path the kubeconfig file is written to.
+	DefaultFileSinkPathTemplate = "/var/run/proxy-kubeconfig-generator/{{ .Namespace }}/{{ .ServiceAccountName }}.kubeconfig"
+	// DefaultFileSinkFileMode is the default mode of the kubeconfig files.
+	DefaultFileSinkFileMode = FileMode(0o600)
+	// DefaultPushgatewayJob is the default job name metrics are pushed under.
+	DefaultPushgatewayJob = "proxy-kubeconfig-generator"
+	// DefaultPushgatewayTimeout is the default Pushgateway request timeout.
+	DefaultPushgatewayTimeout = time.Second * 10
+	// DefaultSealedSecretsScope is the default scope of the generated sealed secrets.
+	DefaultSealedSecretsScope = "strict"
+	// DefaultSealedSecretsCertSecretNamespace is the default namespace of the sealing certificate secret.
+	DefaultSealedSecretsCertSecretNamespace = "kube-system"
+	// DefaultSealedSecretsCertSecretKey is the default key of the sealing certificate in the secret.
+	DefaultSealedSecretsCertSecretKey = "tls.crt"
+	// OwnershipLabel is the label marking secrets created by the generator.
+	OwnershipLabel = "app.kubernetes.io/managed-by"
+	// OwnershipLabelValue is the value of the ownership label.
+	OwnershipLabelValue = "proxy-kubeconfig-generator"
+	// EncryptionSettingsAnnotation is the annotation holding the hash of the encryption settings the kubeconfig was written with.
+	EncryptionSettingsAnnotation = "proxy-kubeconfig-generator/encryption-settings"
+	// SealingSettingsAnnotation is the annotation holding the scope and the sealing certificate fingerprint a sealed secret was sealed with.
+	SealingSettingsAnnotation = "proxy-kubeconfig-generator/sealing-settings"
+	// SourceClusterLabel is the label holding the name of the cluster the generator wrote the secret from.
+	SourceClusterLabel = "proxy-kubeconfig-generator/source-cluster"
+	// FluxKubeConfigSecretKey is the key under which Flux looks up the kubeconfig in a secret by default.
+	FluxKubeConfigSecretKey = "value"
 )
 
 type Config struct {
+	Sinks                     StringValues
+	RemoteClusters            RemoteClusters
+	SourceClusterName         string
 	NamespaceFromCLI          string
 	ServiceAccountName        string
 	TargetNamespaceSelector   NamespaceSelectorLabels
@@ -43,15 +115,59 @@ type Config struct {
 	SourceSecretRevisionLabel string
 	IterationInterval         time.Duration
 
+	EncryptionRecipients                StringValues
+	EncryptionRecipientsFile            string
+	EncryptionRecipientsSecretName      string
+	EncryptionRecipientsSecretNamespace string
+	EncryptedKubeConfigSecretKey        string
+
+	FieldManager             string
+	ForceApply               bool
+	WriteRetrySteps          int
+	WriteRetryInitialBackoff time.Duration
+
+	FluxSecretLayout            bool
+	FluxObjectLabelSelector     string
+	FluxKustomizationAPIVersion string
+	FluxHelmReleaseAPIVersion   string
+
+	ImmutableSecrets           bool
+	ImmutableSecretGracePeriod time.Duration
+
+	EmitEvents          bool
+	EventBurst          int
+	EventRefillInterval time.Duration
+
+	AdoptExisting   bool
 	DisallowUpdates bool
 	ReportOnly      bool
+	Once            bool
+	ShutdownTimeout time.Duration
 }
 
 func (c *Config) TenantSecretName() string {
 	return fmt.Sprintf("%s-kubeconfig", c.ServiceAccountName)
 }
 
-func (c *Config) Validate() error {
+// EncryptionEnabled returns true if the kubeconfig is encrypted before it is stored.
+func (c *Config) EncryptionEnabled() bool {
+	return len(c.EncryptionRecipients.Values) > 0 ||
+		c.EncryptionRecipientsFile != "" ||
+		c.EncryptionRecipientsSecretName != ""
+}
+
+// WriteRetryBackoff returns the backoff used when retrying secret writes.
+func (c *Config) WriteRetryBackoff() wait.Backoff {
+	return wait.Backoff{
+		Steps:    c.WriteRetrySteps,
+		Duration: c.WriteRetryInitialBackoff,
+		Factor:   2.0,
+		Jitter:   0.1,
+	}
+}
+
+// ValidateGenerator validates the configuration required to generate a kubeconfig.
+func (c *Config) ValidateGenerator() error {
 	if c.ServiceAccountName == "" {
 		return fmt.Errorf("missing service account name")
 	}
@@ -66,10 +182,191 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func (c *Config) Validate() error {
+	if err := c.ValidateGenerator(); err != nil {
+		return err
+	}
+
+	if c.FieldManager == "" {
+		return fmt.Errorf("missing field manager")
+	}
+
+	if c.EncryptionEnabled() && c.FluxSecretLayout {
+		return fmt.Errorf("the Flux secret layout cannot be used with encryption")
+	}
+
+	if c.EncryptionEnabled() && c.FluxObjectLabelSelector != "" {
+		return fmt.Errorf("the Flux object label selector cannot be used with encryption, Flux cannot read an encrypted kubeconfig")
+	}
+
+	if c.SourceClusterName != "" {
+		if errs := validation.IsValidLabelValue(c.SourceClusterName); len(errs) > 0 {
+			return fmt.Errorf("invalid source cluster name '%s': %s", c.SourceClusterName, strings.Join(errs, ", "))
+		}
+	}
+
+	if c.WriteRetrySteps < 1 {
+		return fmt.Errorf("write retry steps must be at least 1")
+	}
+
+	if c.EmitEvents && c.EventBurst < 1 {
+		return fmt.Errorf("event burst must be at least 1")
+	}
+
+	if c.EmitEvents && c.EventRefillInterval <= 0 {
+		return fmt.Errorf("event refill interval must be positive")
+	}
+	return nil
+}
+
+type VaultConfig struct {
+	Address      string
+	Mount        string
+	PathTemplate string
+	AuthMount    string
+	AuthRole     string
+	JWTPath      string
+	CACertFile   string
+	Timeout      time.Duration
+}
+
+func (c *VaultConfig) Validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("missing vault address")
+	}
+
+	if c.Mount == "" {
+		return fmt.Errorf("missing vault mount")
+	}
+
+	if c.PathTemplate == "" {
+		return fmt.Errorf("missing vault path template")
+	}
+
+	if c.AuthRole == "" {
+		return fmt.Errorf("missing vault auth role")
+	}
+	return nil
+}
+
+type FileSinkConfig struct {
+	PathTemplate string
+	FileMode     FileMode
+}
+
+func (c *FileSinkConfig) Validate() error {
+	if c.PathTemplate == "" {
+		return fmt.Errorf("missing file sink path template")
+	}
+	return nil
+}
+
+type SealedSecretsConfig struct {
+	CertFile            string
+	CertSecretName      string
+	CertSecretNamespace string
+	CertSecretKey       string
+	Scope               string
+	OutputPathTemplate  string
+}
+
+func (c *SealedSecretsConfig) Validate() error {
+	if c.CertFile == "" && c.CertSecretName == "" {
+		return fmt.Errorf("missing sealing certificate file or secret name")
+	}
+
+	if c.CertFile != "" && c.CertSecretName != "" {
+		return fmt.Errorf("sealing certificate file and secret name are mutually exclusive")
+	}
+	return nil
+}
+
+type PushgatewayConfig struct {
+	URL                string
+	Job                string
+	Grouping           GroupingLabels
+	Username           string
+	PasswordFile       string
+	CACertFile         string
+	ClientCertFile     string
+	ClientKeyFile      string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// Enabled returns true when metrics are pushed to a Pushgateway.
+func (c *PushgatewayConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c *PushgatewayConfig) Validate() error {
+	if c.Job == "" {
+		return fmt.Errorf("missing pushgateway job")
+	}
+
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return fmt.Errorf("pushgateway client certificate and key must be set together")
+	}
+
+	if c.PasswordFile != "" && c.Username == "" {
+		return fmt.Errorf("missing pushgateway username")
+	}
+	return nil
+}
+
 type HttpConfig struct {
 	MetricsBindHostPort string
 	URIPathMetrics      string
 	URIPathHealth       string
+	URIPathPlan         string
+	URIPathStatus       string
+	URIPathReconcile    string
+	URIPathLivez        string
+	URIPathReadyz       string
+
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSClientCAFile       string
+	MetricsDelegatedAuthz bool
+
+	LivenessMaxTickAge        time.Duration
+	ReadinessAPIServerTimeout time.Duration
+
+	// MetricsAppRevisionLabel adds the deprecated app_revision label to the generator metrics.
+	MetricsAppRevisionLabel bool
+
+	ReconcileAllowedGroups          StringValues
+	ReconcileAllowedServiceAccounts StringValues
+}
+
+// TLSEnabled returns true when the server is served over TLS.
+func (c *HttpConfig) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+func (c *HttpConfig) Validate() error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("metrics server TLS certificate and key must be set together")
+	}
+
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		return fmt.Errorf("the metrics server client CA requires a metrics server TLS certificate")
+	}
+
+	if c.MetricsDelegatedAuthz && !c.TLSEnabled() {
+		return fmt.Errorf("delegated metrics authorization requires a metrics server TLS certificate")
+	}
+	return nil
+}
+
+// ValidateLiveness rejects a liveness max tick age the loop can't keep up with,
+// the loop starts a run only every iteration interval.
+func (c *HttpConfig) ValidateLiveness(appConfig *Config) error {
+	if c.LivenessMaxTickAge <= appConfig.IterationInterval {
+		return fmt.Errorf("liveness max tick age %s must be longer than the iteration interval %s",
+			c.LivenessMaxTickAge, appConfig.IterationInterval)
+	}
+	return nil
 }
 
 type LogConfig struct {
@@ -0,0 +1,26 @@
+package configuration
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FileMode is a file mode flag value in the octal notation.
+type FileMode os.FileMode
+
+func (i *FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*i))
+}
+
+func (i *FileMode) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode '%s': %w", value, err)
+	}
+	if mode&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid file mode '%s': only permission bits are allowed", value)
+	}
+	*i = FileMode(mode)
+	return nil
+}
@@ -0,0 +1,37 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupingLabels is a list of Pushgateway grouping labels in the name=value format.
+type GroupingLabels struct {
+	Values []string
+}
+
+func (i *GroupingLabels) String() string {
+	if i.Values == nil {
+		return "<not set>"
+	}
+	return strings.Join(i.Values, ", ")
+}
+
+func (i *GroupingLabels) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid grouping label '%s', expected name=value", value)
+	}
+	i.Values = append(i.Values, value)
+	return nil
+}
+
+// Map returns the grouping labels keyed by name.
+func (i *GroupingLabels) Map() map[string]string {
+	labels := map[string]string{}
+	for _, value := range i.Values {
+		parts := strings.SplitN(value, "=", 2)
+		labels[parts[0]] = parts[1]
+	}
+	return labels
+}
@@ -0,0 +1,70 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultRemoteNamespaceTemplate is the default template of the remote cluster namespace.
+const DefaultRemoteNamespaceTemplate = "{{ .Namespace }}"
+
+// RemoteCluster is a remote cluster the kubeconfig secrets are mirrored to.
+type RemoteCluster struct {
+	Name              string
+	KubeConfigPath    string
+	Context           string
+	NamespaceTemplate string
+}
+
+type RemoteClusters struct {
+	Values []RemoteCluster
+}
+
+func (i *RemoteClusters) String() string {
+	if i.Values == nil {
+		return "<not set>"
+	}
+	names := []string{}
+	for _, value := range i.Values {
+		names = append(names, value.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Set parses a remote cluster in the name=<name>,kubeconfig=<path>[,context=<context>][,namespace=<template>] format.
+func (i *RemoteClusters) Set(value string) error {
+	remoteCluster := RemoteCluster{
+		NamespaceTemplate: DefaultRemoteNamespaceTemplate,
+	}
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid remote cluster option '%s', expected key=value", part)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "name":
+			remoteCluster.Name = kv[1]
+		case "kubeconfig":
+			remoteCluster.KubeConfigPath = kv[1]
+		case "context":
+			remoteCluster.Context = kv[1]
+		case "namespace":
+			remoteCluster.NamespaceTemplate = kv[1]
+		default:
+			return fmt.Errorf("unknown remote cluster option '%s'", kv[0])
+		}
+	}
+	if remoteCluster.Name == "" {
+		return fmt.Errorf("remote cluster requires a name")
+	}
+	if remoteCluster.KubeConfigPath == "" {
+		return fmt.Errorf("remote cluster '%s' requires a kubeconfig", remoteCluster.Name)
+	}
+	for _, existing := range i.Values {
+		if existing.Name == remoteCluster.Name {
+			return fmt.Errorf("remote cluster '%s' defined more than once", remoteCluster.Name)
+		}
+	}
+	i.Values = append(i.Values, remoteCluster)
+	return nil
+}
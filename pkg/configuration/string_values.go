@@ -0,0 +1,20 @@
+package configuration
+
+import "strings"
+
+// StringValues is a list of values of a flag which can be repeated.
+type StringValues struct {
+	Values []string
+}
+
+func (i *StringValues) String() string {
+	if i.Values == nil {
+		return "<not set>"
+	}
+	return strings.Join(i.Values, ", ")
+}
+
+func (i *StringValues) Set(value string) error {
+	i.Values = append(i.Values, value)
+	return nil
+}
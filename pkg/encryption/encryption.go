@@ -0,0 +1,148 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseRecipients parses encryption recipients. The data may contain age X25519
+// recipients and SSH RSA public keys, one per line, and PEM encoded RSA public keys.
+// RSA recipients are wrapped with RSA-OAEP.
+func ParseRecipients(data []byte) ([]age.Recipient, error) {
+	recipients := []age.Recipient{}
+	pemLines := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "-----BEGIN ") || len(pemLines) > 0 {
+			pemLines = append(pemLines, line)
+			if !strings.HasPrefix(line, "-----END ") {
+				continue
+			}
+			block, _ := pem.Decode([]byte(strings.Join(pemLines, "\n")))
+			pemLines = []string{}
+			if block == nil {
+				return nil, fmt.Errorf("invalid PEM block")
+			}
+			recipient, err := parsePEMRecipient(block)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipient, err := ParseRecipient(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pemLines) > 0 {
+		return nil, fmt.Errorf("unterminated PEM block")
+	}
+
+	return recipients, nil
+}
+
+// ParseRecipient parses a single age X25519 recipient or SSH RSA public key.
+func ParseRecipient(value string) (age.Recipient, error) {
+	switch {
+	case strings.HasPrefix(value, "age1"):
+		return age.ParseX25519Recipient(value)
+	case strings.HasPrefix(value, "ssh-rsa "):
+		return agessh.ParseRecipient(value)
+	}
+	return nil, fmt.Errorf("unsupported recipient '%s'", value)
+}
+
+func parsePEMRecipient(block *pem.Block) (age.Recipient, error) {
+	var publicKey interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing PEM public key: %w", err)
+	}
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T, only RSA keys are supported", publicKey)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(rsaPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return agessh.NewRSARecipient(sshPublicKey)
+}
+
+// ParseIdentities parses decryption identities. The data may contain age X25519
+// identities, one per line, or a single PEM encoded RSA private key.
+func ParseIdentities(data []byte) ([]age.Identity, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		identity, err := agessh.ParseIdentity(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing PEM private key: %w", err)
+		}
+		return []age.Identity{identity}, nil
+	}
+	return age.ParseIdentities(bytes.NewReader(data))
+}
+
+// Encrypt encrypts the plaintext to all recipients and returns the ASCII armored ciphertext.
+func Encrypt(plaintext []byte, recipients []age.Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no encryption recipients")
+	}
+	out := &bytes.Buffer{}
+	armorWriter := armor.NewWriter(out)
+	writer, err := age.Encrypt(armorWriter, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts an ASCII armored or binary ciphertext with any of the identities.
+func Decrypt(ciphertext []byte, identities []age.Identity) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		reader = armor.NewReader(bytes.NewReader(bytes.TrimSpace(ciphertext)))
+	}
+	decrypted, err := age.Decrypt(reader, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decrypted)
+}
@@ -0,0 +1,145 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+const testPlaintext = "apiVersion: v1\nkind: Config\n"
+
+func newTestRSAKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	return privateKey, identity
+}
+
+func roundTrip(t *testing.T, recipientData, identityData []byte) {
+	t.Helper()
+	recipients, err := ParseRecipients(recipientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := Encrypt([]byte(testPlaintext), recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(ciphertext), "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Fatalf("expected an armored ciphertext")
+	}
+	identities, err := ParseIdentities(identityData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := Decrypt(ciphertext, identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != testPlaintext {
+		t.Fatalf("expected %q, got %q", testPlaintext, plaintext)
+	}
+}
+
+func TestRoundTripX25519(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, []byte(identity.Recipient().String()), []byte(identity.String()))
+}
+
+func TestRoundTripRSA(t *testing.T) {
+	privateKey, identity := newTestRSAKey(t)
+
+	pkixDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, recipient := range map[string][]byte{
+		"PKIX PEM":  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixDER}),
+		"PKCS1 PEM": pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}),
+		"SSH":       ssh.MarshalAuthorizedKey(sshPublicKey),
+	} {
+		t.Run(name, func(t *testing.T) {
+			roundTrip(t, recipient, identity)
+		})
+	}
+}
+
+func TestRoundTripMultipleRecipients(t *testing.T) {
+	x25519Identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, rsaIdentity := newTestRSAKey(t)
+	pkixDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := strings.Join([]string{
+		"# platform team",
+		x25519Identity.Recipient().String(),
+		"",
+		"# break glass key",
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixDER})),
+	}, "\n")
+
+	roundTrip(t, []byte(recipients), []byte(x25519Identity.String()))
+	roundTrip(t, []byte(recipients), rsaIdentity)
+}
+
+func TestDecryptWrongIdentity(t *testing.T) {
+	recipientIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := Encrypt([]byte(testPlaintext), []age.Recipient{recipientIdentity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(ciphertext, []age.Identity{otherIdentity}); err == nil {
+		t.Fatalf("expected decryption with another identity to fail")
+	}
+}
+
+func TestEncryptNoRecipients(t *testing.T) {
+	if _, err := Encrypt([]byte(testPlaintext), nil); err == nil {
+		t.Fatalf("expected encryption without recipients to fail")
+	}
+}
+
+func TestParseRecipientsInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"unsupported recipient": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl",
+		"invalid age recipient": "age1invalid",
+		"unterminated PEM":      "-----BEGIN PUBLIC KEY-----\nMIIB",
+		"unsupported PEM":       "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----",
+	} {
+		if _, err := ParseRecipients([]byte(data)); err == nil {
+			t.Fatalf("expected %s to fail", name)
+		}
+	}
+}
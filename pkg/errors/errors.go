@@ -7,3 +7,21 @@ import (
 // ErrServerAlreadyRunning is an error returned by the server
 // when Start is called on a n already started server.
 var ErrServerAlreadyRunning = fmt.Errorf("server: already running")
+
+// ErrSecretNotOwned is an error returned when the target secret exists
+// but was not created by the generator and adoption is not enabled.
+var ErrSecretNotOwned = fmt.Errorf("secret: not owned by the generator")
+
+// ErrServiceAccountNotFound is an error returned when the tenant service account
+// does not exist in the target namespace.
+var ErrServiceAccountNotFound = fmt.Errorf("service account: not found")
+
+// ErrNoToken is an error returned when no service account token can be found.
+var ErrNoToken = fmt.Errorf("service account: no token")
+
+// ErrCAMissing is an error returned when the source secret does not exist
+// or does not contain the CA certificate.
+var ErrCAMissing = fmt.Errorf("source secret: CA certificate missing")
+
+// ErrKubeConfigInvalid is an error returned when the generated kubeconfig does not validate.
+var ErrKubeConfigInvalid = fmt.Errorf("kubeconfig: invalid")
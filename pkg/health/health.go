@@ -0,0 +1,145 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+// Check is a named health check.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the result of a single health check.
+type CheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Response is the body of a health endpoint.
+type Response struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Handler runs the checks on every request and responds with 200
+// when all of them pass and 503 otherwise, with per-check details.
+func Handler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := &Response{
+			Status: statusOK,
+			Checks: []CheckResult{},
+		}
+		for _, check := range checks {
+			result := CheckResult{Name: check.Name, Status: statusOK}
+			if err := check.Check(r.Context()); err != nil {
+				result.Status = statusFailed
+				result.Message = err.Error()
+				response.Status = statusFailed
+			}
+			response.Checks = append(response.Checks, result)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if response.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// Tracker records the progress of the reconciliation loop.
+type Tracker struct {
+	sync.RWMutex
+	started           time.Time
+	lastTick          time.Time
+	firstRunCompleted bool
+}
+
+// NewTracker returns a tracker of a loop starting now.
+func NewTracker() *Tracker {
+	return &Tracker{
+		started: time.Now(),
+	}
+}
+
+// Tick records that the loop started a run.
+func (t *Tracker) Tick() {
+	t.Lock()
+	defer t.Unlock()
+	t.lastTick = time.Now()
+}
+
+// RunCompleted records that a run finished, successfully or not.
+func (t *Tracker) RunCompleted() {
+	t.Lock()
+	defer t.Unlock()
+	t.firstRunCompleted = true
+}
+
+// LoopCheck fails when the loop did not start a run within the maximum tick age.
+func (t *Tracker) LoopCheck(maxTickAge time.Duration) Check {
+	return Check{
+		Name: "loop",
+		Check: func(_ context.Context) error {
+			t.RLock()
+			defer t.RUnlock()
+			lastTick := t.lastTick
+			if lastTick.IsZero() {
+				lastTick = t.started
+			}
+			if age := time.Since(lastTick); age > maxTickAge {
+				return fmt.Errorf("loop last ticked %s ago, maximum is %s", age.Round(time.Second), maxTickAge)
+			}
+			return nil
+		},
+	}
+}
+
+// FirstRunCheck fails until the first run completed.
+func (t *Tracker) FirstRunCheck() Check {
+	return Check{
+		Name: "first-run",
+		Check: func(_ context.Context) error {
+			t.RLock()
+			defer t.RUnlock()
+			if !t.firstRunCompleted {
+				return fmt.Errorf("first reconciliation not completed yet")
+			}
+			return nil
+		},
+	}
+}
+
+// APIServerCheck fails when the Kubernetes API server can't be reached within the timeout.
+func APIServerCheck(clientSet kubernetes.Interface, timeout time.Duration) Check {
+	return Check{
+		Name: "api-server",
+		Check: func(ctx context.Context) error {
+			restClient := clientSet.Discovery().RESTClient()
+			if restClient == nil {
+				// Clients without a REST client, like the fake clientset.
+				_, err := clientSet.Discovery().ServerVersion()
+				return err
+			}
+			ctx, cancelFunc := context.WithTimeout(ctx, timeout)
+			defer cancelFunc()
+			if err := restClient.Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+				return fmt.Errorf("api server not reachable: %w", err)
+			}
+			return nil
+		},
+	}
+}
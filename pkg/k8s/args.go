@@ -1,28 +1,38 @@
 package k8s
 
 import (
+	"context"
+
 	"github.com/hashicorp/go-hclog"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/tracing"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 type OperationArgs interface {
 	AppConfig() *configuration.Config
-	ClientSet() *kubernetes.Clientset
+	ClientSet() kubernetes.Interface
+	DynamicClient() dynamic.Interface
+	EventRecorder() record.EventRecorder
 	Logger() hclog.Logger
 }
 
 type defaultOperationArgs struct {
-	appConfig *configuration.Config
-	clientSet *kubernetes.Clientset
-	logger    hclog.Logger
+	appConfig     *configuration.Config
+	clientSet     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	eventRecorder record.EventRecorder
+	logger        hclog.Logger
 }
 
-func NewDefaultOperationArgs(appConfig *configuration.Config, clientSet *kubernetes.Clientset, logger hclog.Logger) OperationArgs {
+func NewDefaultOperationArgs(appConfig *configuration.Config, clientSet kubernetes.Interface, dynamicClient dynamic.Interface, logger hclog.Logger) OperationArgs {
 	return &defaultOperationArgs{
-		appConfig: appConfig,
-		clientSet: clientSet,
-		logger:    logger,
+		appConfig:     appConfig,
+		clientSet:     clientSet,
+		dynamicClient: dynamicClient,
+		logger:        logger,
 	}
 }
 
@@ -30,10 +40,46 @@ func (v *defaultOperationArgs) AppConfig() *configuration.Config {
 	return v.appConfig
 }
 
-func (v *defaultOperationArgs) ClientSet() *kubernetes.Clientset {
+func (v *defaultOperationArgs) ClientSet() kubernetes.Interface {
 	return v.clientSet
 }
 
+func (v *defaultOperationArgs) DynamicClient() dynamic.Interface {
+	return v.dynamicClient
+}
+
+// EventRecorder returns the recorder of Kubernetes events, nil when events are not emitted.
+func (v *defaultOperationArgs) EventRecorder() record.EventRecorder {
+	return v.eventRecorder
+}
+
 func (v *defaultOperationArgs) Logger() hclog.Logger {
 	return v.logger
 }
+
+// WithLogger returns a copy of the operation arguments using the logger.
+func WithLogger(opArgs OperationArgs, logger hclog.Logger) OperationArgs {
+	return &defaultOperationArgs{
+		appConfig:     opArgs.AppConfig(),
+		clientSet:     opArgs.ClientSet(),
+		dynamicClient: opArgs.DynamicClient(),
+		eventRecorder: opArgs.EventRecorder(),
+		logger:        logger,
+	}
+}
+
+// WithEventRecorder returns a copy of the operation arguments emitting Kubernetes events with the recorder.
+func WithEventRecorder(opArgs OperationArgs, eventRecorder record.EventRecorder) OperationArgs {
+	return &defaultOperationArgs{
+		appConfig:     opArgs.AppConfig(),
+		clientSet:     opArgs.ClientSet(),
+		dynamicClient: opArgs.DynamicClient(),
+		eventRecorder: eventRecorder,
+		logger:        opArgs.Logger(),
+	}
+}
+
+// WithTraceLogger returns a copy of the operation arguments logging the trace ID of the span in the context.
+func WithTraceLogger(ctx context.Context, opArgs OperationArgs) OperationArgs {
+	return WithLogger(opArgs, tracing.Logger(ctx, opArgs.Logger()))
+}
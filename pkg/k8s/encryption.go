@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/encryption"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recipientsSource is a named source of encryption recipients.
+type recipientsSource struct {
+	name string
+	data []byte
+}
+
+// loadEncryptionRecipientsSources reads the encryption recipients from the command line,
+// the recipients file and the recipients secret without parsing them.
+func loadEncryptionRecipientsSources(ctx context.Context, opArgs OperationArgs) ([]recipientsSource, error) {
+
+	sources := []recipientsSource{{
+		name: "flags",
+		data: []byte(strings.Join(opArgs.AppConfig().EncryptionRecipients.Values, "\n")),
+	}}
+
+	if opArgs.AppConfig().EncryptionRecipientsFile != "" {
+		data, err := os.ReadFile(opArgs.AppConfig().EncryptionRecipientsFile)
+		if err != nil {
+			opArgs.Logger().Error("Failed reading encryption recipients file",
+				"path", opArgs.AppConfig().EncryptionRecipientsFile,
+				"reason", err)
+			return nil, err
+		}
+		sources = append(sources, recipientsSource{name: opArgs.AppConfig().EncryptionRecipientsFile, data: data})
+	}
+
+	if opArgs.AppConfig().EncryptionRecipientsSecretName != "" {
+		secret, err := opArgs.ClientSet().CoreV1().Secrets(opArgs.AppConfig().EncryptionRecipientsSecretNamespace).Get(ctx,
+			opArgs.AppConfig().EncryptionRecipientsSecretName,
+			metav1.GetOptions{})
+		if err != nil {
+			opArgs.Logger().Error("Failed fetching encryption recipients secret",
+				"namespace", opArgs.AppConfig().EncryptionRecipientsSecretNamespace,
+				"secret-name", opArgs.AppConfig().EncryptionRecipientsSecretName,
+				"reason", err)
+			return nil, err
+		}
+		for key, data := range secret.Data {
+			sources = append(sources, recipientsSource{
+				name: fmt.Sprintf("%s/%s[%s]", secret.Namespace, secret.Name, key),
+				data: data,
+			})
+		}
+	}
+
+	return sources, nil
+}
+
+// EncryptionRecipients are the encryption recipients resolved for a run.
+type EncryptionRecipients struct {
+	Recipients []age.Recipient
+	// SettingsHash is the hash of the recipients, an empty string when encryption is disabled.
+	SettingsHash string
+}
+
+// ResolveEncryptionRecipients loads the encryption recipients from the command line,
+// the recipients file and the recipients secret. The recipients secret is read with
+// the client set of the operation arguments, pass the arguments of the local cluster.
+func ResolveEncryptionRecipients(ctx context.Context, opArgs OperationArgs) (*EncryptionRecipients, error) {
+	if !opArgs.AppConfig().EncryptionEnabled() {
+		return &EncryptionRecipients{}, nil
+	}
+	sources, err := loadEncryptionRecipientsSources(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return nil, err
+	}
+	recipients := []age.Recipient{}
+	for _, source := range sources {
+		sourceRecipients, err := encryption.ParseRecipients(source.data)
+		if err != nil {
+			opArgs.Logger().Error("Failed parsing encryption recipients",
+				"source", source.name,
+				"reason", err)
+			return nil, err
+		}
+		recipients = append(recipients, sourceRecipients...)
+	}
+	return &EncryptionRecipients{
+		Recipients:   recipients,
+		SettingsHash: encryptionSettingsHash(sources),
+	}, nil
+}
+
+// encryptionSettingsHash returns the hash of the recipients in the sources,
+// regardless of their order.
+func encryptionSettingsHash(sources []recipientsSource) string {
+	lines := []string{}
+	for _, source := range sources {
+		for _, line := range strings.Split(string(source.data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte("age\n" + strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+type encryptionRecipientsKey struct{}
+
+// WithEncryptionRecipients returns a context carrying the recipients resolved for the run.
+func WithEncryptionRecipients(ctx context.Context, recipients *EncryptionRecipients) context.Context {
+	return context.WithValue(ctx, encryptionRecipientsKey{}, recipients)
+}
+
+// EnsureEncryptionRecipients returns a context carrying encryption recipients,
+// resolving them with the operation arguments when the context carries none.
+func EnsureEncryptionRecipients(ctx context.Context, opArgs OperationArgs) (context.Context, error) {
+	if _, ok := ctx.Value(encryptionRecipientsKey{}).(*EncryptionRecipients); ok {
+		return ctx, nil
+	}
+	recipients, err := ResolveEncryptionRecipients(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return ctx, err
+	}
+	return WithEncryptionRecipients(ctx, recipients), nil
+}
+
+// encryptionRecipients returns the recipients carried by the context,
+// resolved with the operation arguments when the context carries none.
+func encryptionRecipients(ctx context.Context, opArgs OperationArgs) (*EncryptionRecipients, error) {
+	if recipients, ok := ctx.Value(encryptionRecipientsKey{}).(*EncryptionRecipients); ok {
+		return recipients, nil
+	}
+	return ResolveEncryptionRecipients(ctx, opArgs)
+}
+
+// EncryptionSettingsHash returns the hash of the encryption settings the kubeconfig is written with,
+// an empty string when encryption is disabled. The hash changes when the recipients change,
+// regardless of their order.
+func EncryptionSettingsHash(ctx context.Context, opArgs OperationArgs) (string, error) {
+	recipients, err := encryptionRecipients(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return "", err
+	}
+	return recipients.SettingsHash, nil
+}
+
+// EncryptKubeConfig encrypts the serialized kubeconfig to the configured recipients.
+func EncryptKubeConfig(ctx context.Context, configBuffer []byte, opArgs OperationArgs) ([]byte, error) {
+	recipients, err := encryptionRecipients(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return nil, err
+	}
+	encrypted, err := encryption.Encrypt(configBuffer, recipients.Recipients)
+	if err != nil {
+		opArgs.Logger().Error("Failed encrypting kubeconfig",
+			"number-of-recipients", len(recipients.Recipients),
+			"reason", err)
+		return nil, err
+	}
+	return encrypted, nil
+}
@@ -0,0 +1,175 @@
+package k8s
+
+import (
+	"context"
+	stdErrors "errors"
+	"sync"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// Reasons of the emitted Kubernetes events.
+const (
+	EventReasonCreated                = "Created"
+	EventReasonUpdated                = "Updated"
+	EventReasonServiceAccountNotFound = "ServiceAccountNotFound"
+	EventReasonTokenMissing           = "TokenMissing"
+	EventReasonCAMissing              = "CAMissing"
+	EventReasonUpdateConflict         = "UpdateConflict"
+)
+
+// flushMarkerUID identifies the event marking the end of the queued events.
+// The marker is never written to the API.
+const flushMarkerUID = types.UID("proxy-kubeconfig-generator-flush-marker")
+
+// NewEventRecorder returns a recorder emitting Kubernetes events through the client
+// and a function stopping the recorder.
+// Events of an object are rate limited after the configured burst, identical events
+// are deduplicated into a single event with an increasing count.
+// The stop function waits until the queued events are written or the context is done.
+func NewEventRecorder(clientSet kubernetes.Interface, appConfig *configuration.Config) (record.EventRecorder, func(context.Context)) {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: appConfig.EventBurst,
+		QPS:       float32(1 / appConfig.EventRefillInterval.Seconds()),
+	})
+	sink := &flushingEventSink{
+		EventSink: &typedcorev1.EventSinkImpl{
+			Interface: clientSet.CoreV1().Events(""),
+		},
+		flushed: make(chan struct{}),
+	}
+	broadcaster.StartRecordingToSink(sink)
+	eventRecorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: configuration.OwnershipLabelValue,
+	})
+	return eventRecorder, func(ctx context.Context) {
+		// The broadcaster hands events to the sink in order,
+		// the marker reaches the sink after all events queued before it.
+		eventRecorder.Event(&corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Namespace:  appConfig.ServerTLSSecretNamespace,
+			Name:       string(flushMarkerUID),
+			UID:        flushMarkerUID,
+		}, corev1.EventTypeNormal, "Flush", "Flush marker")
+		select {
+		case <-sink.flushed:
+		case <-ctx.Done():
+		}
+		broadcaster.Shutdown()
+	}
+}
+
+// flushingEventSink writes events to the API and signals when the flush marker arrives.
+type flushingEventSink struct {
+	record.EventSink
+	flushed chan struct{}
+	once    sync.Once
+}
+
+func (s *flushingEventSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	if event.InvolvedObject.UID == flushMarkerUID {
+		s.once.Do(func() { close(s.flushed) })
+		return event, nil
+	}
+	return s.EventSink.Create(event)
+}
+
+func (s *flushingEventSink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	if event.InvolvedObject.UID == flushMarkerUID {
+		s.once.Do(func() { close(s.flushed) })
+		return event, nil
+	}
+	return s.EventSink.Patch(event, data)
+}
+
+// RecordGenerationFailureEvent emits a warning event on the tenant service account
+// when the kubeconfig could not be generated for a reason the tenant can act on.
+// The service account is nil when it could not be fetched.
+func RecordGenerationFailureEvent(targetNamespace string, serviceAccount *corev1.ServiceAccount, opArgs OperationArgs, err error) {
+	var reason, message string
+	switch {
+	case stdErrors.Is(err, errors.ErrServiceAccountNotFound):
+		reason, message = EventReasonServiceAccountNotFound, "Service account not found, kubeconfig not generated"
+	case stdErrors.Is(err, errors.ErrNoToken):
+		reason, message = EventReasonTokenMissing, "Service account has no token, kubeconfig not generated"
+	case stdErrors.Is(err, errors.ErrCAMissing):
+		reason, message = EventReasonCAMissing, "Proxy CA certificate missing, kubeconfig not generated"
+	default:
+		return
+	}
+	recordEvent(opArgs, serviceAccountReference(targetNamespace, serviceAccount, opArgs), corev1.EventTypeWarning, reason, message)
+}
+
+// recordEvent emits an event on the object.
+// Nothing is emitted when events are disabled, in report only mode
+// or when the object reference could not be built.
+func recordEvent(opArgs OperationArgs, object *corev1.ObjectReference, eventType, reason, message string) {
+	if opArgs.EventRecorder() == nil || opArgs.AppConfig().ReportOnly || object == nil {
+		return
+	}
+	opArgs.EventRecorder().Event(object, eventType, reason, message)
+}
+
+// serviceAccountReference returns the reference of the fetched service account,
+// carrying its UID, or a reference by name when the service account is nil.
+func serviceAccountReference(targetNamespace string, serviceAccount *corev1.ServiceAccount, opArgs OperationArgs) *corev1.ObjectReference {
+	if serviceAccount != nil {
+		return objectReference(serviceAccount, opArgs)
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Namespace:  targetNamespace,
+		Name:       opArgs.AppConfig().ServiceAccountName,
+	}
+}
+
+// secretReference returns the reference of the fetched secret,
+// carrying its UID, or a reference by name when the secret is nil.
+func secretReference(targetNamespace, name string, secret *corev1.Secret, opArgs OperationArgs) *corev1.ObjectReference {
+	if secret != nil {
+		return objectReference(secret, opArgs)
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Namespace:  targetNamespace,
+		Name:       name,
+	}
+}
+
+// configMapReference returns the reference of the fetched config map,
+// carrying its UID, or a reference by name when the config map is nil.
+func configMapReference(targetNamespace, name string, configMap *corev1.ConfigMap, opArgs OperationArgs) *corev1.ObjectReference {
+	if configMap != nil {
+		return objectReference(configMap, opArgs)
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  targetNamespace,
+		Name:       name,
+	}
+}
+
+// objectReference returns the reference of an object read from the API.
+// Kubectl describe matches events on the UID of the referenced object.
+func objectReference(object runtime.Object, opArgs OperationArgs) *corev1.ObjectReference {
+	objectReference, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		opArgs.Logger().Warn("Failed building object reference for event",
+			"reason", err)
+		return nil
+	}
+	return objectReference
+}
@@ -0,0 +1,133 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// FluxObjectResources returns the Flux resources which can reference the generated kubeconfig secret.
+func FluxObjectResources(appConfig *configuration.Config) []schema.GroupVersionResource {
+	return []schema.GroupVersionResource{
+		{
+			Group:    "kustomize.toolkit.fluxcd.io",
+			Version:  appConfig.FluxKustomizationAPIVersion,
+			Resource: "kustomizations",
+		},
+		{
+			Group:    "helm.toolkit.fluxcd.io",
+			Version:  appConfig.FluxHelmReleaseAPIVersion,
+			Resource: "helmreleases",
+		},
+	}
+}
+
+// PatchFluxObjects points spec.kubeConfig.secretRef of the Flux objects
+// selected by the Flux object label selector at the generated kubeconfig secret.
+func PatchFluxObjects(ctx context.Context, targetNamespace, secretName string, opArgs OperationArgs) error {
+	for _, gvr := range FluxObjectResources(opArgs.AppConfig()) {
+		list, err := opArgs.DynamicClient().Resource(gvr).Namespace(targetNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: opArgs.AppConfig().FluxObjectLabelSelector,
+		})
+		if apiErrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			opArgs.Logger().Debug("Flux resource not installed, skipping",
+				"namespace", targetNamespace,
+				"resource", gvr.String(),
+				"reason", err)
+			continue
+		}
+		if err != nil {
+			opArgs.Logger().Error("Failed listing Flux objects",
+				"namespace", targetNamespace,
+				"resource", gvr.String(),
+				"selector", opArgs.AppConfig().FluxObjectLabelSelector,
+				"reason", err)
+			return err
+		}
+		for _, item := range list.Items {
+			if err := patchFluxObject(ctx, targetNamespace, secretName, gvr, item, opArgs); err != nil { // Logging taken care of.
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func patchFluxObject(ctx context.Context, targetNamespace, secretName string, gvr schema.GroupVersionResource, item unstructured.Unstructured, opArgs OperationArgs) error {
+
+	// With the Flux secret layout, Flux reads the key it reads by default,
+	// a key set on the object is removed.
+	key := ""
+	if !opArgs.AppConfig().FluxSecretLayout {
+		key = opArgs.AppConfig().KubeConfigSecretKey
+	}
+	secretRef := map[string]interface{}{
+		"name": secretName,
+		"key":  nil,
+	}
+	if key != "" {
+		secretRef["key"] = key
+	}
+
+	existingName, _, _ := unstructured.NestedString(item.Object, "spec", "kubeConfig", "secretRef", "name")
+	existingKey, _, _ := unstructured.NestedString(item.Object, "spec", "kubeConfig", "secretRef", "key")
+	if existingName == secretName && existingKey == key {
+		opArgs.Logger().Debug("Flux object already references the kubeconfig secret",
+			"namespace", targetNamespace,
+			"resource", gvr.String(),
+			"name", item.GetName(),
+			"secret-name", secretName)
+		return nil
+	}
+
+	if opArgs.AppConfig().ReportOnly {
+		opArgs.Logger().Info("Report only: would patch a Flux object",
+			"namespace", targetNamespace,
+			"resource", gvr.String(),
+			"name", item.GetName(),
+			"secret-name", secretName)
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"kubeConfig": map[string]interface{}{
+				"secretRef": secretRef,
+			},
+		},
+	})
+	if err != nil {
+		opArgs.Logger().Error("Failed serializing Flux object patch",
+			"reason", err)
+		return err
+	}
+
+	_, err = opArgs.DynamicClient().Resource(gvr).Namespace(targetNamespace).Patch(ctx,
+		item.GetName(),
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{})
+	if err != nil {
+		opArgs.Logger().Error("Failed patching Flux object",
+			"namespace", targetNamespace,
+			"resource", gvr.String(),
+			"name", item.GetName(),
+			"reason", err)
+		return err
+	}
+
+	opArgs.Logger().Info("Flux object patched",
+		"namespace", targetNamespace,
+		"resource", gvr.String(),
+		"name", item.GetName(),
+		"secret-name", secretName)
+
+	return nil
+}
@@ -0,0 +1,403 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/retry"
+)
+
+// ImmutableSecretName returns the name of the immutable kubeconfig secret
+// generated for the revision of the source secret and the encryption settings,
+// so that changing either rotates the secret.
+func ImmutableSecretName(sourceSecret *corev1.Secret, encryptionSettings string, opArgs OperationArgs) string {
+	revision := SourceResourceRevision(sourceSecret)
+	if encryptionSettings != "" {
+		revision = fmt.Sprintf("%s/%s", revision, encryptionSettings)
+	}
+	sum := sha256.Sum256([]byte(revision))
+	return fmt.Sprintf("%s-%s", opArgs.AppConfig().TenantSecretName(), hex.EncodeToString(sum[:])[0:10])
+}
+
+// CurrentImmutableSecretName returns the name of the immutable kubeconfig secret
+// the alias ConfigMap in the target namespace points at.
+func CurrentImmutableSecretName(ctx context.Context, targetNamespace string, opArgs OperationArgs) (string, error) {
+	aliasName := opArgs.AppConfig().TenantSecretName()
+	alias, err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Get(ctx, aliasName, metav1.GetOptions{})
+	if err != nil {
+		opArgs.Logger().Error("Failed fetching alias config map",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName,
+			"reason", err)
+		return "", err
+	}
+	secretName, ok := alias.Data[configuration.ImmutableSecretAliasKey]
+	if !ok || secretName == "" {
+		err := fmt.Errorf("alias config map '%s' in namespace '%s' does not name a secret", aliasName, targetNamespace)
+		opArgs.Logger().Error("Alias config map does not point at a secret",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName,
+			"reason", err)
+		return "", err
+	}
+	return secretName, nil
+}
+
+// CreateOrRotateImmutableKubeConfigSecret creates an immutable kubeconfig secret for the current
+// source secret revision and points the alias ConfigMap at it. Superseded secrets are deleted
+// once the grace period expires.
+func CreateOrRotateImmutableKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (Result, error) {
+
+	encryptionSettings, err := EncryptionSettingsHash(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return ResultFailed, err
+	}
+
+	aliasName := opArgs.AppConfig().TenantSecretName()
+	secretName := ImmutableSecretName(sourceSecret, encryptionSettings, opArgs)
+	sourceResourceRevision := SourceResourceRevision(sourceSecret)
+
+	hasExistingAlias := true
+	// adoptingAlias is set when the existing alias config map was not created by the generator.
+	adoptingAlias := false
+	// recreate is set when the alias points at the current secret but the secret was deleted.
+	recreate := false
+	existingAlias, err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Get(ctx, aliasName, metav1.GetOptions{})
+	if err != nil {
+		if !apiErrors.IsNotFound(err) {
+			opArgs.Logger().Error("Failed checking if alias config map exists",
+				"namespace", targetNamespace,
+				"config-map-name", aliasName,
+				"reason", err)
+			return ResultFailed, err
+		}
+		hasExistingAlias = false
+	}
+
+	if hasExistingAlias {
+
+		if !hasOwnershipLabel(existingAlias.Labels) {
+			if !opArgs.AppConfig().AdoptExisting {
+				err := fmt.Errorf("%w: config map '%s' in namespace '%s' does not carry the '%s' label",
+					errors.ErrSecretNotOwned,
+					aliasName,
+					targetNamespace,
+					configuration.OwnershipLabel)
+				metrics.RecordNotOwned(opArgs.AppConfig(), targetNamespace)
+				recordEvent(opArgs, configMapReference(targetNamespace, aliasName, existingAlias, opArgs), corev1.EventTypeWarning,
+					EventReasonUpdateConflict, "Config map is not owned by the generator, kubeconfig not written")
+				opArgs.Logger().Error("Refusing to modify a config map not owned by the generator",
+					"namespace", targetNamespace,
+					"config-map-name", aliasName,
+					"reason", err)
+				return ResultFailed, err
+			}
+			opArgs.Logger().Warn("Adopting existing config map not owned by the generator",
+				"namespace", targetNamespace,
+				"config-map-name", aliasName)
+			adoptingAlias = true
+		}
+
+		if existingAlias.Data[configuration.ImmutableSecretAliasKey] == secretName {
+			currentSecret, err := getSecretIfExists(ctx, targetNamespace, secretName, opArgs)
+			if err != nil { // Logging taken care of.
+				return ResultFailed, err
+			}
+			if currentSecret != nil {
+				opArgs.Logger().Info("Nothing to do, current immutable secret was generated using current source secret resource version",
+					"namespace", targetNamespace,
+					"secret-name", secretName,
+					"source-secret-resource-version", sourceResourceRevision)
+				return ResultUnchanged, deleteSupersededImmutableSecrets(ctx, targetNamespace, secretName, opArgs)
+			}
+			opArgs.Logger().Warn("Alias config map points at a missing immutable secret, recreating the secret",
+				"namespace", targetNamespace,
+				"config-map-name", aliasName,
+				"secret-name", secretName)
+			recreate = true
+		}
+
+		if opArgs.AppConfig().DisallowUpdates && !recreate {
+			opArgs.Logger().Info("Alias config map exists and updates are disabled",
+				"namespace", targetNamespace,
+				"config-map-name", aliasName,
+				"current-secret-name", existingAlias.Data[configuration.ImmutableSecretAliasKey],
+				"source-secret-resource-version", sourceResourceRevision)
+			return ResultSkipped, nil
+		}
+	}
+
+	configBuffer, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		opArgs.Logger().Error("Failed serializing kubeconfig to buffer",
+			"reason", err)
+		return ResultFailed, err
+	}
+
+	if opArgs.AppConfig().ReportOnly {
+		opArgs.Logger().Info("Report only: would create an immutable secret",
+			"namespace", targetNamespace,
+			"secret-name", secretName,
+			"config-map-name", aliasName,
+			"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
+			"source-secret-resource-version", sourceResourceRevision,
+			"secret-data-size", len(configBuffer))
+		return ResultReportOnly, nil
+	}
+
+	secretData, err := KubeConfigSecretData(ctx, configBuffer, opArgs)
+	if err != nil { // Logging taken care of.
+		return ResultFailed, err
+	}
+
+	immutable := true
+	var annotations map[string]string
+	if encryptionSettings != "" {
+		annotations = map[string]string{
+			configuration.EncryptionSettingsAnnotation: encryptionSettings,
+		}
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Annotations: annotations,
+			Labels:      immutableSecretLabels(aliasName, sourceResourceRevision, opArgs),
+		},
+		Immutable: &immutable,
+		Data:      secretData,
+	}
+
+	var createdSecret *corev1.Secret
+	err = retry.OnError(opArgs.AppConfig().WriteRetryBackoff(), isRetriableWriteError, func() error {
+		var err error
+		createdSecret, err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Create(ctx, secret, metav1.CreateOptions{
+			FieldManager: opArgs.AppConfig().FieldManager,
+		})
+		if apiErrors.IsAlreadyExists(err) {
+			// A previous run created the secret but did not update the alias.
+			createdSecret, err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Get(ctx, secretName, metav1.GetOptions{})
+		}
+		return err
+	})
+
+	if err != nil {
+		metrics.RecordCreateFailure(opArgs.AppConfig(), targetNamespace)
+		opArgs.Logger().Error("Failed creating immutable secret",
+			"target-namespace", targetNamespace,
+			"secret-name", secretName,
+			"reason", err)
+		return ResultFailed, err
+	}
+
+	metrics.RecordCreateSuccess(opArgs.AppConfig(), targetNamespace)
+	recordEvent(opArgs, secretReference(targetNamespace, secretName, createdSecret, opArgs), corev1.EventTypeNormal,
+		EventReasonCreated, "Immutable kubeconfig secret created")
+
+	alias := corev1apply.ConfigMap(aliasName, targetNamespace).
+		WithLabels(generatedObjectLabels(sourceResourceRevision, opArgs)).
+		WithData(map[string]string{
+			configuration.ImmutableSecretAliasKey: secretName,
+		})
+
+	// An adopted config map belongs to another field manager, the apply takes its fields over.
+	force := opArgs.AppConfig().ForceApply || adoptingAlias
+	err = retry.OnError(opArgs.AppConfig().WriteRetryBackoff(), isRetriableWriteError, func() error {
+		_, err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Apply(ctx, alias, metav1.ApplyOptions{
+			FieldManager: opArgs.AppConfig().FieldManager,
+			Force:        force,
+		})
+		return err
+	})
+	if err != nil {
+		if apiErrors.IsConflict(err) {
+			var conflictingAlias *corev1.ConfigMap
+			if hasExistingAlias {
+				conflictingAlias = existingAlias
+			}
+			recordEvent(opArgs, configMapReference(targetNamespace, aliasName, conflictingAlias, opArgs), corev1.EventTypeWarning,
+				EventReasonUpdateConflict, "Config map write conflicted with another field manager, giving up until the next run")
+		}
+		opArgs.Logger().Error("Failed pointing alias config map at the immutable secret",
+			"target-namespace", targetNamespace,
+			"config-map-name", aliasName,
+			"secret-name", secretName,
+			"reason", err)
+		return ResultFailed, err
+	}
+
+	opArgs.Logger().Info("Immutable secret created and alias updated",
+		"target-namespace", targetNamespace,
+		"secret-name", secretName,
+		"config-map-name", aliasName,
+		"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
+		"source-secret-resource-version", sourceResourceRevision)
+
+	result := ResultCreated
+	if hasExistingAlias && !recreate {
+		result = ResultUpdated
+	}
+	return result, deleteSupersededImmutableSecrets(ctx, targetNamespace, secretName, opArgs)
+}
+
+// DeleteImmutableKubeConfigSecrets deletes all immutable kubeconfig secrets
+// and the alias config map from the target namespace.
+func DeleteImmutableKubeConfigSecrets(ctx context.Context, targetNamespace string, opArgs OperationArgs) error {
+
+	aliasName := opArgs.AppConfig().TenantSecretName()
+
+	if opArgs.AppConfig().ReportOnly {
+		opArgs.Logger().Info("Report only: would delete immutable secrets",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName)
+		return nil
+	}
+
+	err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).DeleteCollection(ctx,
+		metav1.DeleteOptions{},
+		metav1.ListOptions{
+			LabelSelector: immutableSecretsLabelSelector(opArgs),
+		})
+	if err != nil {
+		opArgs.Logger().Error("Failed deleting immutable secrets",
+			"namespace", targetNamespace,
+			"reason", err)
+		return err
+	}
+
+	existingAlias, err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Get(ctx, aliasName, metav1.GetOptions{})
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			return nil
+		}
+		opArgs.Logger().Error("Failed checking if alias config map exists",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName,
+			"reason", err)
+		return err
+	}
+
+	if value, ok := existingAlias.Labels[configuration.OwnershipLabel]; !ok || value != configuration.OwnershipLabelValue {
+		opArgs.Logger().Warn("Not deleting a config map not owned by the generator",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName)
+		return nil
+	}
+
+	if err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Delete(ctx,
+		aliasName,
+		metav1.DeleteOptions{}); err != nil && !apiErrors.IsNotFound(err) {
+		opArgs.Logger().Error("Failed deleting alias config map",
+			"namespace", targetNamespace,
+			"config-map-name", aliasName,
+			"reason", err)
+		return err
+	}
+
+	opArgs.Logger().Info("Immutable secrets and alias deleted",
+		"namespace", targetNamespace,
+		"config-map-name", aliasName)
+
+	return nil
+}
+
+// immutableSecretLabels returns the labels of an immutable kubeconfig secret.
+func immutableSecretLabels(aliasName, sourceResourceRevision string, opArgs OperationArgs) map[string]string {
+	labels := generatedObjectLabels(sourceResourceRevision, opArgs)
+	labels[configuration.ImmutableSecretGroupLabel] = aliasName
+	return labels
+}
+
+func immutableSecretsLabelSelector(opArgs OperationArgs) string {
+	return fmt.Sprintf("%s=%s,%s=%s",
+		configuration.OwnershipLabel, configuration.OwnershipLabelValue,
+		configuration.ImmutableSecretGroupLabel, opArgs.AppConfig().TenantSecretName())
+}
+
+// deleteSupersededImmutableSecrets marks immutable secrets other than the current one as superseded
+// and deletes those superseded for longer than the grace period.
+func deleteSupersededImmutableSecrets(ctx context.Context, targetNamespace, currentSecretName string, opArgs OperationArgs) error {
+
+	if opArgs.AppConfig().ReportOnly {
+		return nil
+	}
+
+	secrets, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: immutableSecretsLabelSelector(opArgs),
+	})
+	if err != nil {
+		opArgs.Logger().Error("Failed listing immutable secrets",
+			"namespace", targetNamespace,
+			"reason", err)
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	for _, secret := range secrets.Items {
+		if secret.Name == currentSecretName {
+			continue
+		}
+
+		supersededAt, ok := secret.Annotations[configuration.ImmutableSecretSupersededAnnotation]
+		if !ok {
+			patch := fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}}}`,
+				configuration.ImmutableSecretSupersededAnnotation, now.Format(time.RFC3339))
+			if _, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Patch(ctx,
+				secret.Name,
+				types.MergePatchType,
+				[]byte(patch),
+				metav1.PatchOptions{}); err != nil {
+				opArgs.Logger().Error("Failed marking immutable secret as superseded",
+					"namespace", targetNamespace,
+					"secret-name", secret.Name,
+					"reason", err)
+				return err
+			}
+			opArgs.Logger().Info("Immutable secret superseded",
+				"namespace", targetNamespace,
+				"secret-name", secret.Name,
+				"current-secret-name", currentSecretName)
+			continue
+		}
+
+		supersededTime, err := time.Parse(time.RFC3339, supersededAt)
+		if err != nil {
+			opArgs.Logger().Warn("Invalid superseded annotation on immutable secret, deleting",
+				"namespace", targetNamespace,
+				"secret-name", secret.Name,
+				"reason", err)
+		} else if now.Sub(supersededTime) < opArgs.AppConfig().ImmutableSecretGracePeriod {
+			continue
+		}
+
+		if err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Delete(ctx,
+			secret.Name,
+			metav1.DeleteOptions{}); err != nil && !apiErrors.IsNotFound(err) {
+			opArgs.Logger().Error("Failed deleting superseded immutable secret",
+				"namespace", targetNamespace,
+				"secret-name", secret.Name,
+				"reason", err)
+			return err
+		}
+
+		opArgs.Logger().Info("Superseded immutable secret deleted",
+			"namespace", targetNamespace,
+			"secret-name", secret.Name,
+			"superseded-at", supersededAt)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/rest"
+)
+
+// LocalCluster is the cluster label of requests to the cluster the generator runs in.
+const LocalCluster = "local"
+
+// InstrumentClientConfig records the duration of every request made with clients
+// built from the configuration, labelled with the cluster, and traces every request.
+func InstrumentClientConfig(config *rest.Config, cluster string) {
+	config.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(&instrumentedRoundTripper{
+			cluster: cluster,
+			next:    next,
+		}, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			verb, resource := requestVerbAndResource(req)
+			if resource == "" {
+				return fmt.Sprintf("kubernetes %s %s", verb, req.URL.Path)
+			}
+			return fmt.Sprintf("kubernetes %s %s", verb, resource)
+		}), otelhttp.WithSpanOptions(trace.WithAttributes(
+			attribute.String("k8s.cluster.name", cluster))))
+	})
+}
+
+type instrumentedRoundTripper struct {
+	cluster string
+	next    http.RoundTripper
+}
+
+func (t *instrumentedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	verb, resource := requestVerbAndResource(req)
+	metrics.RecordKubernetesRequestDuration(t.cluster, verb, resource, time.Since(start).Seconds())
+	return resp, err
+}
+
+// requestVerbAndResource returns the Kubernetes verb and the resource of an API request,
+// like get and secrets. Subresources are appended to the resource, like serviceaccounts/token.
+// The resource of requests to non-resource URLs is empty.
+func requestVerbAndResource(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	// /api/{version}/... or /apis/{group}/{version}/...
+	var segments []string
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		segments = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		segments = parts[3:]
+	default:
+		return strings.ToLower(req.Method), ""
+	}
+	// namespaces/{namespace}/{resource}/..., but not namespaces/{name} itself.
+	if len(segments) > 2 && segments[0] == "namespaces" {
+		segments = segments[2:]
+	}
+
+	resource := segments[0]
+	hasName := len(segments) > 1
+	if len(segments) > 2 {
+		resource = resource + "/" + segments[2]
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasName {
+			return "get", resource
+		}
+		if req.URL.Query().Get("watch") == "true" {
+			return "watch", resource
+		}
+		return "list", resource
+	case http.MethodPost:
+		return "create", resource
+	case http.MethodPut:
+		return "update", resource
+	case http.MethodPatch:
+		if req.Header.Get("Content-Type") == "application/apply-patch+yaml" {
+			return "apply", resource
+		}
+		return "patch", resource
+	case http.MethodDelete:
+		if hasName {
+			return "delete", resource
+		}
+		return "deletecollection", resource
+	default:
+		return strings.ToLower(req.Method), resource
+	}
+}
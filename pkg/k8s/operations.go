@@ -2,17 +2,23 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/plan"
 	corev1 "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/retry"
 )
 
 // BuildKubeConfigFromToken builds a kubeconfig.
@@ -49,7 +55,7 @@ func BuildKubeConfigFromToken(token []byte, CACertificate []byte, opArgs Operati
 		opArgs.Logger().Error("kubeconfig did not validate",
 			"namespace", opArgs.AppConfig().ServerTLSSecretNamespace,
 			"reason", err)
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", errors.ErrKubeConfigInvalid, err)
 	}
 
 	return &config, nil
@@ -72,8 +78,76 @@ func BuildKubernetesClientConfig(logger hclog.Logger) (*rest.Config, error) {
 	return config, nil
 }
 
+// BuildRemoteKubernetesClientConfig creates a Kubernetes client configuration
+// for a remote cluster from a kubeconfig file.
+func BuildRemoteKubernetesClientConfig(kubeConfigPath, context string) (*rest.Config, error) {
+	loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeConfigPath}
+	configOverrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides).ClientConfig()
+}
+
+// DeleteKubeConfigSecret deletes the kubeconfig secret from the target namespace
+// if the secret is owned by the generator.
+func DeleteKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs) error {
+
+	if opArgs.AppConfig().ImmutableSecrets {
+		return DeleteImmutableKubeConfigSecrets(ctx, targetNamespace, opArgs)
+	}
+
+	existingSecret, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Get(
+		ctx,
+		opArgs.AppConfig().TenantSecretName(),
+		metav1.GetOptions{})
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			return nil
+		}
+		opArgs.Logger().Error("Failed checking if secret exists",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName(),
+			"reason", err)
+		return err
+	}
+
+	if !IsOwnedSecret(existingSecret, opArgs) {
+		opArgs.Logger().Warn("Not deleting a secret not owned by the generator",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName())
+		return nil
+	}
+
+	if opArgs.AppConfig().ReportOnly {
+		opArgs.Logger().Info("Report only: would delete a secret",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName())
+		return nil
+	}
+
+	err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Delete(
+		ctx,
+		opArgs.AppConfig().TenantSecretName(),
+		metav1.DeleteOptions{})
+	if err != nil && !apiErrors.IsNotFound(err) {
+		opArgs.Logger().Error("Failed deleting secret",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName(),
+			"reason", err)
+		return err
+	}
+
+	opArgs.Logger().Info("Secret deleted",
+		"namespace", targetNamespace,
+		"secret-name", opArgs.AppConfig().TenantSecretName())
+
+	return nil
+}
+
 // CreateOrUpdateKubeConfigSecret creates or updates a kubeconfig secret in the target namespace.
-func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) error {
+func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (Result, error) {
+
+	if opArgs.AppConfig().ImmutableSecrets {
+		return CreateOrRotateImmutableKubeConfigSecret(ctx, targetNamespace, opArgs, kubeconfig, sourceSecret)
+	}
 
 	hasExistingSecret := true
 	existingSecret, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Get(
@@ -89,7 +163,7 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 				"namespace", targetNamespace,
 				"secret-name", opArgs.AppConfig().TenantSecretName(),
 				"reason", err)
-			return err
+			return ResultFailed, err
 		}
 	}
 
@@ -103,33 +177,65 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 	if err != nil {
 		opArgs.Logger().Error("Failed serializing kubeconfig to buffer",
 			"reason", err)
-		return err
+		return ResultFailed, err
+	}
+
+	sourceResourceRevision := SourceResourceRevision(sourceSecret)
+
+	encryptionSettings, err := EncryptionSettingsHash(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return ResultFailed, err
 	}
 
-	sourceResourceRevision := fmt.Sprintf("%s_%d", sourceSecret.ResourceVersion, sourceSecret.Generation)
+	if !hasExistingSecret {
+		existingSecret = nil
+	}
+
+	switch kubeConfigSecretAction(existingSecret, sourceResourceRevision, encryptionSettings, opArgs) {
+	case plan.ActionNotOwned:
+		err := fmt.Errorf("%w: secret '%s' in namespace '%s' does not carry the '%s' label",
+			errors.ErrSecretNotOwned,
+			existingSecret.Name,
+			targetNamespace,
+			configuration.OwnershipLabel)
+		metrics.RecordNotOwned(opArgs.AppConfig(), targetNamespace)
+		recordEvent(opArgs, secretReference(targetNamespace, existingSecret.Name, existingSecret, opArgs), corev1.EventTypeWarning,
+			EventReasonUpdateConflict, "Secret is not owned by the generator, kubeconfig not written")
+		opArgs.Logger().Error("Refusing to modify a secret not owned by the generator",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName(),
+			"reason", err)
+		return ResultFailed, err
+	case plan.ActionUpdatesDisabled:
+		opArgs.Logger().Info("Secret exists and updates are disabled",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName(),
+			"existing-secret-generation", existingSecret.Generation,
+			"existing-secret-resource-version", existingSecret.ResourceVersion,
+			"source-secret-resource-version", sourceResourceRevision)
+		return ResultSkipped, nil
+	case plan.ActionNone:
+		opArgs.Logger().Info("Nothing to do, secret already exists and and was generated using current source secret resource version",
+			"namespace", targetNamespace,
+			"secret-name", opArgs.AppConfig().TenantSecretName(),
+			"source-secret-resource-version", sourceResourceRevision)
+		return ResultUnchanged, nil
+	}
 
 	if hasExistingSecret {
 
-		if opArgs.AppConfig().DisallowUpdates {
-			opArgs.Logger().Info("Secret exists and updates are disabled",
+		if !IsOwnedSecret(existingSecret, opArgs) {
+			opArgs.Logger().Warn("Adopting existing secret not owned by the generator",
 				"namespace", targetNamespace,
 				"secret-name", opArgs.AppConfig().TenantSecretName(),
-				"existing-secret-generation", existingSecret.Generation,
-				"existing-secret-resource-version", existingSecret.ResourceVersion,
-				"source-secret-resource-version", sourceResourceRevision)
-			return nil
+				"existing-secret-resource-version", existingSecret.ResourceVersion)
 		}
+		// A secret without the ownership label belongs to another field manager,
+		// the apply takes its fields over.
+		force := opArgs.AppConfig().ForceApply || !hasOwnershipLabel(existingSecret.Labels)
 
 		existingSecretSourceVersion := "<not set>"
-
 		if targetLastKnownRevision, labelExists := existingSecret.Labels[opArgs.AppConfig().SourceSecretRevisionLabel]; labelExists {
-			if targetLastKnownRevision == sourceResourceRevision {
-				opArgs.Logger().Info("Nothing to do, secret already exists and and was generated using current source secret resource version",
-					"namespace", targetNamespace,
-					"secret-name", opArgs.AppConfig().TenantSecretName(),
-					"source-secret-resource-version", sourceResourceRevision)
-				return nil
-			}
 			existingSecretSourceVersion = targetLastKnownRevision
 		}
 
@@ -142,17 +248,6 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 			"existing-secret-resource-version", existingSecret.ResourceVersion,
 			"secret-data-size", len(configBuffer))
 
-		secretToUpdate := existingSecret.DeepCopy()
-		if secretToUpdate.Labels == nil {
-			secretToUpdate.Labels = map[string]string{}
-		}
-		if secretToUpdate.Data == nil {
-			secretToUpdate.Data = map[string][]byte{}
-		}
-
-		secretToUpdate.Labels[opArgs.AppConfig().SourceSecretRevisionLabel] = sourceResourceRevision
-		secretToUpdate.Data[opArgs.AppConfig().KubeConfigSecretKey] = configBuffer
-
 		if opArgs.AppConfig().ReportOnly {
 			opArgs.Logger().Info("Report only: would update a secret",
 				"namespace", targetNamespace,
@@ -160,23 +255,21 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 				"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
 				"source-secret-resource-version", sourceResourceRevision,
 				"secret-data-size", len(configBuffer))
-			return nil
+			return ResultReportOnly, nil
 		}
 
-		updateBenchStart := time.Now().UTC().UnixMilli()
-
-		_, err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Update(
-			ctx,
-			secretToUpdate,
-			metav1.UpdateOptions{},
-		)
+		// The kubeconfig is only encrypted when it is written.
+		secretData, err := KubeConfigSecretData(ctx, configBuffer, opArgs)
+		if err != nil { // Logging taken care of.
+			return ResultFailed, err
+		}
+		secretToApply := kubeConfigSecretApplyConfiguration(targetNamespace, sourceResourceRevision, encryptionSettings, secretData, opArgs)
 
-		metrics.RecordTargetSecretUpdateLatency(opArgs.AppConfig(),
-			targetNamespace,
-			float64(time.Now().UnixMilli()-updateBenchStart))
+		updatedSecret, err := applyKubeConfigSecret(ctx, targetNamespace, "update", secretToApply, force, opArgs)
 
 		if err != nil {
-
+			metrics.RecordUpdateFailure(opArgs.AppConfig(), targetNamespace)
+			recordConflictEvent(targetNamespace, existingSecret, opArgs, err)
 			opArgs.Logger().Error("Failed updating secret",
 				"target-namespace", targetNamespace,
 				"target-secret-name", opArgs.AppConfig().TenantSecretName(),
@@ -184,9 +277,19 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 				"source-secret-resource-version", sourceResourceRevision,
 				"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
 				"reason", err)
-			return err
+			return ResultFailed, err
 		}
 
+		// Keys written with other encryption settings may be owned by another field manager,
+		// the apply does not remove them.
+		if err := removeStaleKubeConfigSecretKeys(ctx, targetNamespace, existingSecret, opArgs); err != nil { // Logging taken care of.
+			metrics.RecordUpdateFailure(opArgs.AppConfig(), targetNamespace)
+			return ResultFailed, err
+		}
+
+		metrics.RecordUpdateSuccess(opArgs.AppConfig(), targetNamespace)
+		recordEvent(opArgs, secretReference(targetNamespace, opArgs.AppConfig().TenantSecretName(), updatedSecret, opArgs), corev1.EventTypeNormal,
+			EventReasonUpdated, "Kubeconfig secret updated")
 		opArgs.Logger().Info("Secret updated",
 			"target-namespace", targetNamespace,
 			"target-secret-name", opArgs.AppConfig().TenantSecretName(),
@@ -195,22 +298,10 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 			"old-resource-version", existingSecretSourceVersion,
 			"new-resource-version", sourceResourceRevision)
 
-		return nil // end of update
+		return ResultUpdated, nil // end of update
 
 	}
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: opArgs.AppConfig().TenantSecretName(),
-			Labels: map[string]string{
-				opArgs.AppConfig().SourceSecretRevisionLabel: sourceResourceRevision,
-			},
-		},
-		Data: map[string][]byte{
-			opArgs.AppConfig().KubeConfigSecretKey: configBuffer,
-		},
-	}
-
 	if opArgs.AppConfig().ReportOnly {
 		opArgs.Logger().Info("Report only: would create a secret",
 			"namespace", targetNamespace,
@@ -218,42 +309,218 @@ func CreateOrUpdateKubeConfigSecret(ctx context.Context, targetNamespace string,
 			"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
 			"source-secret-resource-version", sourceResourceRevision,
 			"secret-data-size", len(configBuffer))
-		return nil
+		return ResultReportOnly, nil
 	}
 
-	createBenchStart := time.Now().UTC().UnixMilli()
-
-	_, err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Create(
-		ctx,
-		secret,
-		metav1.CreateOptions{},
-	)
+	secretData, err := KubeConfigSecretData(ctx, configBuffer, opArgs)
+	if err != nil { // Logging taken care of.
+		return ResultFailed, err
+	}
+	secret := kubeConfigSecretApplyConfiguration(targetNamespace, sourceResourceRevision, encryptionSettings, secretData, opArgs)
 
-	metrics.RecordTargetSecretCreateLatency(opArgs.AppConfig(),
-		targetNamespace,
-		float64(time.Now().UnixMilli()-createBenchStart))
+	createdSecret, err := applyKubeConfigSecret(ctx, targetNamespace, "create", secret, opArgs.AppConfig().ForceApply, opArgs)
 
 	if err != nil {
 		metrics.RecordCreateFailure(opArgs.AppConfig(), targetNamespace)
+		recordConflictEvent(targetNamespace, nil, opArgs, err)
 		opArgs.Logger().Error("Failed creating secret",
 			"target-namespace", opArgs.AppConfig().TenantSecretName(),
 			"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
 			"reason", err)
-		return err
+		return ResultFailed, err
 	}
 
 	metrics.RecordCreateSuccess(opArgs.AppConfig(), targetNamespace)
+	recordEvent(opArgs, secretReference(targetNamespace, opArgs.AppConfig().TenantSecretName(), createdSecret, opArgs), corev1.EventTypeNormal,
+		EventReasonCreated, "Kubeconfig secret created")
 	opArgs.Logger().Info("Secret created",
 		"target-namespace", opArgs.AppConfig().TenantSecretName(),
 		"secret-name", opArgs.AppConfig().TenantSecretName(),
 		"secret-key", opArgs.AppConfig().KubeConfigSecretKey,
 		"source-secret-resource-version", sourceResourceRevision)
 
+	return ResultCreated, nil
+}
+
+// SourceResourceRevision returns the revision of the source secret
+// recorded on the generated secrets.
+func SourceResourceRevision(sourceSecret *corev1.Secret) string {
+	return fmt.Sprintf("%s_%d", sourceSecret.ResourceVersion, sourceSecret.Generation)
+}
+
+// IsOwnedSecret returns true if the secret was created by the generator.
+// Secrets created before the ownership label was introduced are recognized
+// by the source secret revision label.
+func IsOwnedSecret(secret *corev1.Secret, opArgs OperationArgs) bool {
+	if hasOwnershipLabel(secret.Labels) {
+		return true
+	}
+	_, ok := secret.Labels[opArgs.AppConfig().SourceSecretRevisionLabel]
+	return ok
+}
+
+// hasOwnershipLabel returns true when the labels mark an object created by the generator.
+func hasOwnershipLabel(labels map[string]string) bool {
+	value, ok := labels[configuration.OwnershipLabel]
+	return ok && value == configuration.OwnershipLabelValue
+}
+
+// generatedObjectLabels returns the labels of the objects written by the generator.
+func generatedObjectLabels(sourceResourceRevision string, opArgs OperationArgs) map[string]string {
+	labels := map[string]string{
+		configuration.OwnershipLabel:                 configuration.OwnershipLabelValue,
+		opArgs.AppConfig().SourceSecretRevisionLabel: sourceResourceRevision,
+	}
+	if opArgs.AppConfig().SourceClusterName != "" {
+		labels[configuration.SourceClusterLabel] = opArgs.AppConfig().SourceClusterName
+	}
+	return labels
+}
+
+// kubeConfigSecretApplyConfiguration returns the apply configuration
+// containing only the fields of the kubeconfig secret owned by the generator.
+func kubeConfigSecretApplyConfiguration(targetNamespace, sourceResourceRevision, encryptionSettings string, secretData map[string][]byte, opArgs OperationArgs) *corev1apply.SecretApplyConfiguration {
+	secret := corev1apply.Secret(opArgs.AppConfig().TenantSecretName(), targetNamespace).
+		WithLabels(generatedObjectLabels(sourceResourceRevision, opArgs)).
+		WithData(secretData)
+	if encryptionSettings != "" {
+		secret = secret.WithAnnotations(map[string]string{
+			configuration.EncryptionSettingsAnnotation: encryptionSettings,
+		})
+	}
+	return secret
+}
+
+// removeStaleKubeConfigSecretKeys removes the kubeconfig keys written with other encryption
+// settings from the secret, and the encryption settings annotation when encryption is disabled.
+func removeStaleKubeConfigSecretKeys(ctx context.Context, targetNamespace string, existingSecret *corev1.Secret, opArgs OperationArgs) error {
+	staleKeys := staleKubeConfigSecretKeys(existingSecret, opArgs)
+	_, hasAnnotation := existingSecret.Annotations[configuration.EncryptionSettingsAnnotation]
+	removeAnnotation := hasAnnotation && !opArgs.AppConfig().EncryptionEnabled()
+	if len(staleKeys) == 0 && !removeAnnotation {
+		return nil
+	}
+
+	data := map[string]interface{}{}
+	for _, key := range staleKeys {
+		data[key] = nil
+	}
+	metadata := map[string]interface{}{}
+	if removeAnnotation {
+		metadata["annotations"] = map[string]interface{}{
+			configuration.EncryptionSettingsAnnotation: nil,
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+		"data":     data,
+	})
+	if err != nil {
+		opArgs.Logger().Error("Failed serializing stale keys patch",
+			"reason", err)
+		return err
+	}
+
+	if _, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Patch(ctx,
+		existingSecret.Name,
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{FieldManager: opArgs.AppConfig().FieldManager}); err != nil {
+		opArgs.Logger().Error("Failed removing stale kubeconfig keys",
+			"namespace", targetNamespace,
+			"secret-name", existingSecret.Name,
+			"stale-keys", staleKeys,
+			"reason", err)
+		return err
+	}
+
+	opArgs.Logger().Info("Removed stale kubeconfig keys",
+		"namespace", targetNamespace,
+		"secret-name", existingSecret.Name,
+		"stale-keys", staleKeys)
 	return nil
 }
 
+// applyKubeConfigSecret writes the secret using server-side apply,
+// retrying transient API errors with a backoff.
+// Field ownership conflicts are not retried, they fail the same way on every attempt.
+// With force, conflicting fields are taken over. Returns the secret written to the API.
+func applyKubeConfigSecret(ctx context.Context, targetNamespace, operation string, secret *corev1apply.SecretApplyConfiguration, force bool, opArgs OperationArgs) (*corev1.Secret, error) {
+	attempt := 0
+	var applied *corev1.Secret
+	err := retry.OnError(opArgs.AppConfig().WriteRetryBackoff(), isRetriableWriteError, func() error {
+		attempt = attempt + 1
+		if attempt > 1 {
+			metrics.RecordWriteRetry(opArgs.AppConfig(), operation, targetNamespace)
+		}
+		var err error
+		applied, err = opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Apply(ctx,
+			secret,
+			metav1.ApplyOptions{
+				FieldManager: opArgs.AppConfig().FieldManager,
+				Force:        force,
+			})
+		if err != nil {
+			if apiErrors.IsConflict(err) {
+				metrics.RecordWriteConflict(opArgs.AppConfig(), operation, targetNamespace)
+			}
+			opArgs.Logger().Warn("Secret apply attempt failed",
+				"namespace", targetNamespace,
+				"secret-name", opArgs.AppConfig().TenantSecretName(),
+				"operation", operation,
+				"attempt", attempt,
+				"reason", err)
+		}
+		return err
+	})
+	return applied, err
+}
+
+// recordConflictEvent emits a warning event on the kubeconfig secret
+// when writing the secret failed with a conflict.
+// The existing secret is nil when the secret was being created.
+func recordConflictEvent(targetNamespace string, existingSecret *corev1.Secret, opArgs OperationArgs, err error) {
+	if !apiErrors.IsConflict(err) {
+		return
+	}
+	recordEvent(opArgs, secretReference(targetNamespace, opArgs.AppConfig().TenantSecretName(), existingSecret, opArgs), corev1.EventTypeWarning,
+		EventReasonUpdateConflict, "Kubeconfig secret write conflicted with another field manager, giving up until the next run")
+}
+
+func isRetriableWriteError(err error) bool {
+	return apiErrors.IsServerTimeout(err) ||
+		apiErrors.IsTimeout(err) ||
+		apiErrors.IsTooManyRequests(err) ||
+		apiErrors.IsInternalError(err) ||
+		apiErrors.IsServiceUnavailable(err)
+}
+
+// KubeConfigSecretData returns the data of the kubeconfig secret.
+// When the Flux secret layout is enabled, the kubeconfig is additionally
+// stored under the key Flux reads by default. When encryption is enabled,
+// only the encrypted kubeconfig is stored.
+func KubeConfigSecretData(ctx context.Context, configBuffer []byte, opArgs OperationArgs) (map[string][]byte, error) {
+	if opArgs.AppConfig().EncryptionEnabled() {
+		encrypted, err := EncryptKubeConfig(ctx, configBuffer, opArgs)
+		if err != nil { // Logging taken care of.
+			return nil, err
+		}
+		return map[string][]byte{
+			opArgs.AppConfig().EncryptedKubeConfigSecretKey: encrypted,
+		}, nil
+	}
+	data := map[string][]byte{
+		opArgs.AppConfig().KubeConfigSecretKey: configBuffer,
+	}
+	if opArgs.AppConfig().FluxSecretLayout {
+		data[configuration.FluxKubeConfigSecretKey] = configBuffer
+	}
+	return data, nil
+}
+
 // GetServiceAccountSecret retrieves a secret for the service account.
-func GetServiceAccountSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs) (*corev1.Secret, error) {
+// The service account is returned when it could be fetched, also on error.
+func GetServiceAccountSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs) (*corev1.ServiceAccount, *corev1.Secret, error) {
 
 	serviceAccount, err := opArgs.ClientSet().CoreV1().ServiceAccounts(targetNamespace).Get(
 		ctx,
@@ -265,16 +532,19 @@ func GetServiceAccountSecret(ctx context.Context, targetNamespace string, opArgs
 			"namespace", targetNamespace,
 			"service-account-name", opArgs.AppConfig().ServiceAccountName,
 			"reason", err)
-		return nil, err
+		if apiErrors.IsNotFound(err) {
+			return nil, nil, fmt.Errorf("%w: %w", errors.ErrServiceAccountNotFound, err)
+		}
+		return nil, nil, err
 	}
 
 	if len(serviceAccount.Secrets) < 1 {
-		err := fmt.Errorf("no secret found for the service account '%s' in namepsace '%s'", serviceAccount.Name, serviceAccount.Namespace)
+		err := fmt.Errorf("%w: no secret found for the service account '%s' in namepsace '%s'", errors.ErrNoToken, serviceAccount.Name, serviceAccount.Namespace)
 		opArgs.Logger().Error("No secret found for the service account",
 			"namespace", serviceAccount.Namespace,
 			"service-account-name", serviceAccount.Name,
 			"reason", err)
-		return nil, err
+		return serviceAccount, nil, err
 	}
 
 	saSecret, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Get(
@@ -288,21 +558,16 @@ func GetServiceAccountSecret(ctx context.Context, targetNamespace string, opArgs
 			"service-account-name", serviceAccount.Name,
 			"service-account-secret-name", serviceAccount.Secrets[0].Name,
 			"reason", err)
-		return nil, err
+		return serviceAccount, nil, err
 	}
 
-	return saSecret, nil
+	return serviceAccount, saSecret, nil
 }
 
 // GetSourceSecret loads the source secret.
-func GetSourceSecret(opArgs OperationArgs) (*corev1.Secret, error) {
-	benchStart := time.Now().UTC().UnixMilli()
-	defer func() {
-		metrics.RecordSourceSecretLoadLatency(opArgs.AppConfig(),
-			float64(time.Now().UTC().UnixMilli()-benchStart))
-	}()
+func GetSourceSecret(ctx context.Context, opArgs OperationArgs) (*corev1.Secret, error) {
 	s, err := opArgs.ClientSet().CoreV1().Secrets(opArgs.AppConfig().ServerTLSSecretNamespace).Get(
-		context.Background(),
+		ctx,
 		opArgs.AppConfig().ServerTLSSecretName,
 		metav1.GetOptions{})
 	if err != nil {
@@ -310,6 +575,9 @@ func GetSourceSecret(opArgs OperationArgs) (*corev1.Secret, error) {
 			"namespace", opArgs.AppConfig().ServerTLSSecretNamespace,
 			"service-account-name", opArgs.AppConfig().ServerTLSSecretName,
 			"reason", err)
+		if apiErrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: %w", errors.ErrCAMissing, err)
+		}
 		return nil, err
 	}
 	return s, nil
@@ -319,7 +587,7 @@ func GetSourceSecret(opArgs OperationArgs) (*corev1.Secret, error) {
 func GetSourceSecretField(secret *corev1.Secret, opArgs OperationArgs) ([]byte, error) {
 	field, ok := secret.Data[opArgs.AppConfig().ServerTLSSecretCAKey]
 	if !ok {
-		err := fmt.Errorf("no '%s' key for tenant kubeconfig secret '%s'", opArgs.AppConfig().ServerTLSSecretCAKey, secret.Name)
+		err := fmt.Errorf("%w: no '%s' key for tenant kubeconfig secret '%s'", errors.ErrCAMissing, opArgs.AppConfig().ServerTLSSecretCAKey, secret.Name)
 		opArgs.Logger().Error("Required secret CA key not found in secret",
 			"namespace", opArgs.AppConfig().ServerTLSSecretNamespace,
 			"service-account-name", opArgs.AppConfig().ServerTLSSecretName,
@@ -0,0 +1,191 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const testSecretPath = "/api/v1/namespaces/team-a/secrets/tenant-kubeconfig"
+
+// fakeSecretAPI serves a single secret. When the secret fields belong to another
+// field manager, applies conflict unless they are forced.
+type fakeSecretAPI struct {
+	lock          sync.Mutex
+	secret        *corev1.Secret
+	foreignFields bool
+	applies       []bool
+}
+
+func (a *fakeSecretAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if r.URL.Path != testSecretPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.secret)
+	case http.MethodPatch:
+		force := r.URL.Query().Get("force") == "true"
+		a.applies = append(a.applies, force)
+		if !force && a.foreignFields {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(&metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure,
+				Reason:   metav1.StatusReasonConflict,
+				Code:     http.StatusConflict,
+				Message:  "Apply failed with 1 conflict: conflict with \"kubectl\"",
+			})
+			return
+		}
+		patch, _ := io.ReadAll(r.Body)
+		existing, _ := json.Marshal(a.secret)
+		patched, err := jsonpatch.MergePatch(existing, patch)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		secret := &corev1.Secret{}
+		if err := json.Unmarshal(patched, secret); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.secret = secret
+		a.foreignFields = false
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.secret)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestOperationArgs(t *testing.T, api *fakeSecretAPI, appConfig *configuration.Config) OperationArgs {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	clientSet, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDefaultOperationArgs(appConfig, clientSet, nil, hclog.NewNullLogger())
+}
+
+func newTestAppConfig() *configuration.Config {
+	return &configuration.Config{
+		ServiceAccountName:        "tenant",
+		KubeConfigSecretKey:       "kubeconfig",
+		SourceSecretRevisionLabel: "proxy-kubeconfig-generator/source-revision",
+		Server:                    "https://proxy.example.com",
+		ServerTLSSecretNamespace:  "proxy",
+		FieldManager:              "proxy-kubeconfig-generator",
+		WriteRetrySteps:           1,
+	}
+}
+
+func newHandWrittenSecret() *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "tenant-kubeconfig",
+			Namespace:       "team-a",
+			ResourceVersion: "1",
+		},
+		Data: map[string][]byte{"kubeconfig": []byte("written by hand")},
+	}
+}
+
+func TestCreateOrUpdateKubeConfigSecretNotOwned(t *testing.T) {
+	api := &fakeSecretAPI{secret: newHandWrittenSecret(), foreignFields: true}
+	opArgs := newTestOperationArgs(t, api, newTestAppConfig())
+	kubeconfig, err := BuildKubeConfigFromToken([]byte("token"), []byte("ca"), opArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"}}
+
+	result, err := CreateOrUpdateKubeConfigSecret(context.Background(), "team-a", opArgs, kubeconfig, sourceSecret)
+	if !stdErrors.Is(err, errors.ErrSecretNotOwned) || result != ResultFailed {
+		t.Fatalf("expected a not owned failure, got %s: %v", result, err)
+	}
+	if len(api.applies) != 0 {
+		t.Fatalf("expected no applies, got %d", len(api.applies))
+	}
+}
+
+func TestCreateOrUpdateKubeConfigSecretAdoptExisting(t *testing.T) {
+	api := &fakeSecretAPI{secret: newHandWrittenSecret(), foreignFields: true}
+	appConfig := newTestAppConfig()
+	appConfig.AdoptExisting = true
+	// Adopting takes the fields over regardless of the force apply setting.
+	appConfig.ForceApply = false
+	opArgs := newTestOperationArgs(t, api, appConfig)
+	kubeconfig, err := BuildKubeConfigFromToken([]byte("token"), []byte("ca"), opArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"}}
+	ctx := context.Background()
+
+	result, err := CreateOrUpdateKubeConfigSecret(ctx, "team-a", opArgs, kubeconfig, sourceSecret)
+	if err != nil || result != ResultUpdated {
+		t.Fatalf("expected the secret to be adopted, got %s: %v", result, err)
+	}
+	if len(api.applies) != 1 || !api.applies[0] {
+		t.Fatalf("expected a single forced apply, got %v", api.applies)
+	}
+	if api.secret.Labels[configuration.OwnershipLabel] != configuration.OwnershipLabelValue {
+		t.Fatalf("expected the ownership label, got %v", api.secret.Labels)
+	}
+
+	result, err = CreateOrUpdateKubeConfigSecret(ctx, "team-a", opArgs, kubeconfig, sourceSecret)
+	if err != nil || result != ResultUnchanged {
+		t.Fatalf("expected unchanged, got %s: %v", result, err)
+	}
+}
+
+func TestCreateOrUpdateKubeConfigSecretConflictNotRetried(t *testing.T) {
+	secret := newHandWrittenSecret()
+	secret.Labels = map[string]string{
+		configuration.OwnershipLabel:                 configuration.OwnershipLabelValue,
+		"proxy-kubeconfig-generator/source-revision": "0",
+	}
+	// Another field manager set the kubeconfig key.
+	api := &fakeSecretAPI{secret: secret, foreignFields: true}
+	appConfig := newTestAppConfig()
+	appConfig.ForceApply = false
+	appConfig.WriteRetrySteps = 5
+	opArgs := newTestOperationArgs(t, api, appConfig)
+	kubeconfig, err := BuildKubeConfigFromToken([]byte("token"), []byte("ca"), opArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1"}}
+
+	result, err := CreateOrUpdateKubeConfigSecret(context.Background(), "team-a", opArgs, kubeconfig, sourceSecret)
+	if err == nil || result != ResultFailed {
+		t.Fatalf("expected a conflict, got %s: %v", result, err)
+	}
+	if len(api.applies) != 1 {
+		t.Fatalf("expected the conflict not to be retried, got %d applies", len(api.applies))
+	}
+}
@@ -0,0 +1,285 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/plan"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// encryptedValue is shown instead of a fingerprint when the existing kubeconfig is encrypted.
+const encryptedValue = "<encrypted>"
+
+// PlanKubeConfigSecret computes what CreateOrUpdateKubeConfigSecret would do
+// in the target namespace without modifying anything.
+func PlanKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (*plan.Entry, error) {
+	if opArgs.AppConfig().ImmutableSecrets {
+		return planImmutableKubeConfigSecret(ctx, targetNamespace, opArgs, kubeconfig, sourceSecret)
+	}
+
+	sourceResourceRevision := SourceResourceRevision(sourceSecret)
+	entry := &plan.Entry{
+		Namespace:  targetNamespace,
+		SecretName: opArgs.AppConfig().TenantSecretName(),
+	}
+
+	existingSecret, err := getSecretIfExists(ctx, targetNamespace, entry.SecretName, opArgs)
+	if err != nil { // Logging taken care of.
+		return nil, err
+	}
+
+	encryptionSettings, err := EncryptionSettingsHash(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return nil, err
+	}
+
+	entry.Action = kubeConfigSecretAction(existingSecret, sourceResourceRevision, encryptionSettings, opArgs)
+	entry.Changes = kubeConfigSecretChanges(existingSecret, entry.Action, kubeconfig, generatedObjectLabels(sourceResourceRevision, opArgs), map[string]string{
+		configuration.EncryptionSettingsAnnotation: encryptionSettings,
+	}, opArgs)
+
+	return entry, nil
+}
+
+func planImmutableKubeConfigSecret(ctx context.Context, targetNamespace string, opArgs OperationArgs, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (*plan.Entry, error) {
+
+	encryptionSettings, err := EncryptionSettingsHash(ctx, opArgs)
+	if err != nil { // Logging taken care of.
+		return nil, err
+	}
+
+	aliasName := opArgs.AppConfig().TenantSecretName()
+	secretName := ImmutableSecretName(sourceSecret, encryptionSettings, opArgs)
+	sourceResourceRevision := SourceResourceRevision(sourceSecret)
+	entry := &plan.Entry{
+		Namespace:  targetNamespace,
+		SecretName: secretName,
+	}
+
+	existingAlias, err := opArgs.ClientSet().CoreV1().ConfigMaps(targetNamespace).Get(ctx, aliasName, metav1.GetOptions{})
+	if err != nil {
+		if !apiErrors.IsNotFound(err) {
+			opArgs.Logger().Error("Failed checking if alias config map exists",
+				"namespace", targetNamespace,
+				"config-map-name", aliasName,
+				"reason", err)
+			return nil, err
+		}
+		existingAlias = nil
+	}
+
+	var existingSecret *corev1.Secret
+	currentSecretName := ""
+	switch {
+	case existingAlias == nil:
+		entry.Action = plan.ActionCreate
+	case existingAlias.Labels[configuration.OwnershipLabel] != configuration.OwnershipLabelValue && !opArgs.AppConfig().AdoptExisting:
+		entry.Action = plan.ActionNotOwned
+	case existingAlias.Data[configuration.ImmutableSecretAliasKey] == secretName:
+		entry.Action = plan.ActionNone
+	case opArgs.AppConfig().DisallowUpdates:
+		entry.Action = plan.ActionUpdatesDisabled
+	default:
+		entry.Action = plan.ActionUpdate
+	}
+
+	if existingAlias != nil {
+		currentSecretName = existingAlias.Data[configuration.ImmutableSecretAliasKey]
+		if currentSecretName != "" {
+			existingSecret, err = getSecretIfExists(ctx, targetNamespace, currentSecretName, opArgs)
+			if err != nil { // Logging taken care of.
+				return nil, err
+			}
+		}
+	}
+
+	// The alias points at the current secret but the secret was deleted, it is recreated.
+	if entry.Action == plan.ActionNone && existingSecret == nil {
+		entry.Action = plan.ActionCreate
+	}
+
+	if currentSecretName != secretName {
+		entry.Changes = append(entry.Changes, plan.Change{
+			Field: "secret-name",
+			Old:   currentSecretName,
+			New:   secretName,
+		})
+	}
+	entry.Changes = append(entry.Changes, kubeConfigSecretChanges(existingSecret, entry.Action, kubeconfig, immutableSecretLabels(aliasName, sourceResourceRevision, opArgs), map[string]string{
+		configuration.EncryptionSettingsAnnotation: encryptionSettings,
+	}, opArgs)...)
+	if existingSecret != nil && entry.Action == plan.ActionUpdate {
+		entry.Changes = append(entry.Changes, plan.Change{
+			Field: fmt.Sprintf("annotation:%s (%s)", configuration.ImmutableSecretSupersededAnnotation, currentSecretName),
+			Old:   existingSecret.Annotations[configuration.ImmutableSecretSupersededAnnotation],
+			New:   "<now>",
+		})
+	}
+
+	return entry, nil
+}
+
+// kubeConfigSecretAction decides what to do with the kubeconfig secret,
+// the existing secret is nil when it does not exist.
+// The secret is up to date when it was written from the current source secret revision
+// with the current encryption settings and source cluster name and holds no stale kubeconfig keys.
+func kubeConfigSecretAction(existingSecret *corev1.Secret, sourceResourceRevision, encryptionSettings string, opArgs OperationArgs) plan.Action {
+	if existingSecret == nil {
+		return plan.ActionCreate
+	}
+	if !IsOwnedSecret(existingSecret, opArgs) && !opArgs.AppConfig().AdoptExisting {
+		return plan.ActionNotOwned
+	}
+	if opArgs.AppConfig().DisallowUpdates {
+		return plan.ActionUpdatesDisabled
+	}
+	if targetLastKnownRevision, ok := existingSecret.Labels[opArgs.AppConfig().SourceSecretRevisionLabel]; ok && targetLastKnownRevision == sourceResourceRevision &&
+		existingSecret.Annotations[configuration.EncryptionSettingsAnnotation] == encryptionSettings &&
+		existingSecret.Labels[configuration.SourceClusterLabel] == opArgs.AppConfig().SourceClusterName &&
+		len(staleKubeConfigSecretKeys(existingSecret, opArgs)) == 0 {
+		return plan.ActionNone
+	}
+	return plan.ActionUpdate
+}
+
+// staleKubeConfigSecretKeys returns the keys of the existing secret written with
+// different encryption settings: the plain kubeconfig keys when encryption is enabled
+// and the encrypted kubeconfig key when it is disabled.
+func staleKubeConfigSecretKeys(existingSecret *corev1.Secret, opArgs OperationArgs) []string {
+	candidates := []string{opArgs.AppConfig().EncryptedKubeConfigSecretKey}
+	if opArgs.AppConfig().EncryptionEnabled() {
+		candidates = []string{opArgs.AppConfig().KubeConfigSecretKey, configuration.FluxKubeConfigSecretKey}
+	}
+	stale := []string{}
+	for _, key := range candidates {
+		if _, ok := existingSecret.Data[key]; ok {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// kubeConfigSecretChanges compares the existing secret with the desired kubeconfig, labels and annotations.
+// Credentials are compared by their fingerprints. An encrypted kubeconfig can't be compared,
+// its fields are reported as encrypted unless the action is none: the secret was then written
+// from the current source secret revision with the current encryption settings.
+func kubeConfigSecretChanges(existingSecret *corev1.Secret, action plan.Action, kubeconfig *clientcmdapi.Config, labels, annotations map[string]string, opArgs OperationArgs) []plan.Change {
+
+	changes := []plan.Change{}
+	appendChange := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, plan.Change{Field: field, Old: old, New: new})
+		}
+	}
+
+	newServer, newCA, newToken := kubeConfigSummary(kubeconfig)
+	oldServer, oldCA, oldToken := "", "", ""
+	oldKeys := []string{}
+	existingLabels := map[string]string{}
+	existingAnnotations := map[string]string{}
+
+	if existingSecret != nil {
+		existingLabels = existingSecret.Labels
+		existingAnnotations = existingSecret.Annotations
+		for key := range existingSecret.Data {
+			oldKeys = append(oldKeys, key)
+		}
+		if data, ok := existingSecret.Data[opArgs.AppConfig().KubeConfigSecretKey]; ok {
+			if existingConfig, err := clientcmd.Load(data); err == nil {
+				oldServer, oldCA, oldToken = kubeConfigSummary(existingConfig)
+			} else {
+				oldServer = "<unreadable>"
+			}
+		} else if _, ok := existingSecret.Data[opArgs.AppConfig().EncryptedKubeConfigSecretKey]; ok {
+			if action == plan.ActionNone {
+				oldServer, oldCA, oldToken = newServer, newCA, newToken
+			} else {
+				oldServer, oldCA, oldToken = encryptedValue, encryptedValue, encryptedValue
+			}
+		}
+	}
+
+	appendChange("server", oldServer, newServer)
+	appendChange("ca-fingerprint", oldCA, newCA)
+	appendChange("token-fingerprint", oldToken, newToken)
+	appendChange("data-keys", joinSorted(oldKeys), joinSorted(kubeConfigSecretDataKeys(opArgs)))
+
+	labelKeys := []string{}
+	for key := range labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		appendChange("label:"+key, existingLabels[key], labels[key])
+	}
+
+	annotationKeys := []string{}
+	for key := range annotations {
+		annotationKeys = append(annotationKeys, key)
+	}
+	sort.Strings(annotationKeys)
+	for _, key := range annotationKeys {
+		appendChange("annotation:"+key, existingAnnotations[key], annotations[key])
+	}
+
+	return changes
+}
+
+// kubeConfigSummary returns the server, the CA fingerprint and the token
+// fingerprint of the current context of the kubeconfig.
+func kubeConfigSummary(kubeconfig *clientcmdapi.Config) (string, string, string) {
+	kubeContext, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return "", "", ""
+	}
+	server, ca, token := "", "", ""
+	if cluster, ok := kubeconfig.Clusters[kubeContext.Cluster]; ok {
+		server = cluster.Server
+		ca = plan.Fingerprint(cluster.CertificateAuthorityData)
+	}
+	if authInfo, ok := kubeconfig.AuthInfos[kubeContext.AuthInfo]; ok {
+		token = plan.Fingerprint([]byte(authInfo.Token))
+	}
+	return server, ca, token
+}
+
+// kubeConfigSecretDataKeys returns the keys KubeConfigSecretData writes.
+func kubeConfigSecretDataKeys(opArgs OperationArgs) []string {
+	if opArgs.AppConfig().EncryptionEnabled() {
+		return []string{opArgs.AppConfig().EncryptedKubeConfigSecretKey}
+	}
+	keys := []string{opArgs.AppConfig().KubeConfigSecretKey}
+	if opArgs.AppConfig().FluxSecretLayout {
+		keys = append(keys, configuration.FluxKubeConfigSecretKey)
+	}
+	return keys
+}
+
+func getSecretIfExists(ctx context.Context, targetNamespace, secretName string, opArgs OperationArgs) (*corev1.Secret, error) {
+	secret, err := opArgs.ClientSet().CoreV1().Secrets(targetNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		opArgs.Logger().Error("Failed checking if secret exists",
+			"namespace", targetNamespace,
+			"secret-name", secretName,
+			"reason", err)
+		return nil, err
+	}
+	return secret, nil
+}
+
+func joinSorted(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
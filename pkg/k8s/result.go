@@ -0,0 +1,72 @@
+package k8s
+
+import (
+	stdErrors "errors"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+)
+
+// Result is the outcome of storing a kubeconfig.
+type Result string
+
+const (
+	// ResultCreated means the kubeconfig did not exist and was created.
+	ResultCreated Result = "created"
+	// ResultUpdated means the kubeconfig existed and was updated.
+	ResultUpdated Result = "updated"
+	// ResultUnchanged means the kubeconfig was already up to date.
+	ResultUnchanged Result = "unchanged"
+	// ResultSkipped means the kubeconfig is outdated and the write was skipped,
+	// because updates are disabled.
+	ResultSkipped Result = "skipped"
+	// ResultReportOnly means the write was skipped because of the report only mode.
+	ResultReportOnly Result = "report_only"
+	// ResultFailed means the kubeconfig could not be stored.
+	ResultFailed Result = "failed"
+)
+
+// resultLabelSkippedDisallowed is the namespace results metric label of skipped results.
+const resultLabelSkippedDisallowed = "skipped_disallowed"
+
+// Failure reasons of the namespace results metric.
+const (
+	ReasonServiceAccountNotFound = "sa_not_found"
+	ReasonNoToken                = "no_token"
+	ReasonCAMissing              = "ca_missing"
+	ReasonValidateFailed         = "validate_failed"
+	ReasonNotOwned               = "not_owned"
+	ReasonAPIError               = "api_error"
+)
+
+// FailureReason classifies the error of a failed namespace.
+func FailureReason(err error) string {
+	switch {
+	case stdErrors.Is(err, errors.ErrServiceAccountNotFound):
+		return ReasonServiceAccountNotFound
+	case stdErrors.Is(err, errors.ErrNoToken):
+		return ReasonNoToken
+	case stdErrors.Is(err, errors.ErrCAMissing):
+		return ReasonCAMissing
+	case stdErrors.Is(err, errors.ErrKubeConfigInvalid):
+		return ReasonValidateFailed
+	case stdErrors.Is(err, errors.ErrSecretNotOwned):
+		return ReasonNotOwned
+	default:
+		return ReasonAPIError
+	}
+}
+
+// RecordResult records the outcome of storing the kubeconfig of the namespace in the sink.
+// The error is classified when it is not nil.
+func RecordResult(sink, namespace string, result Result, err error) {
+	if err != nil {
+		metrics.RecordNamespaceResult(sink, namespace, string(ResultFailed), FailureReason(err))
+		return
+	}
+	label := string(result)
+	if result == ResultSkipped {
+		label = resultLabelSkippedDisallowed
+	}
+	metrics.RecordNamespaceResult(sink, namespace, label, "")
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/version"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_build_info",
+		Help: "Proxy kubeconfig generator build information, always 1",
+	}, []string{"version",
+		"commit",
+		"go_version"})
+
+	startTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_start_time_seconds",
+		Help: "Start time of the proxy kubeconfig generator since the Unix epoch in seconds",
+	})
+)
+
+func init() {
+	buildInfo.WithLabelValues(version.Version, version.Commit, version.GoVersion()).Set(1)
+	startTime.Set(float64(time.Now().Unix()))
+}
+
+// gatherer gathers the metrics served and pushed by the generator.
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+// Gatherer returns the gatherer of the metrics served and pushed by the generator.
+func Gatherer() prometheus.Gatherer {
+	return gatherer
+}
+
+// EnableAppRevisionLabel adds the app_revision label the generator metrics carried
+// before build information was exposed. Must be called before metrics are served.
+//
+// Deprecated: app_revision changes on every restart, use proxy_kubeconfig_generator_start_time_seconds.
+// Will be removed in the next release.
+func EnableAppRevisionLabel() {
+	gatherer = &appRevisionGatherer{
+		gatherer: prometheus.DefaultGatherer,
+		label: &dto.LabelPair{
+			Name:  proto.String("app_revision"),
+			Value: proto.String(configuration.AppRevision()),
+		},
+	}
+}
+
+// appRevisionGatherer adds the app_revision label to the generator metrics,
+// except to the build information and the start time.
+type appRevisionGatherer struct {
+	gatherer prometheus.Gatherer
+	label    *dto.LabelPair
+}
+
+func (g *appRevisionGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.gatherer.Gather()
+	for _, metricFamily := range metricFamilies {
+		name := metricFamily.GetName()
+		if !strings.HasPrefix(name, "proxy_kubeconfig_generator_") ||
+			name == "proxy_kubeconfig_generator_build_info" ||
+			name == "proxy_kubeconfig_generator_start_time_seconds" {
+			continue
+		}
+		for _, metric := range metricFamily.Metric {
+			metric.Label = append(metric.Label, g.label)
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+	return metricFamilies, err
+}
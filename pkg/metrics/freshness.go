@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+)
+
+var (
+	lastSuccessTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_last_success_timestamp_seconds",
+		Help: "Time the kubeconfig of the namespace was last stored successfully by the sink since the Unix epoch in seconds",
+	}, []string{"sink",
+		"gen_service_account_name",
+		"gen_target_secret_namespace"})
+
+	credentialExpiryTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_credential_expiry_timestamp_seconds",
+		Help: "Expiry of the credential in the last generated kubeconfig of the namespace since the Unix epoch in seconds, absent when the credential does not expire",
+	}, []string{"gen_service_account_name",
+		"gen_target_secret_namespace"})
+
+	sourceRevisionInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_source_revision_info",
+		Help: "Source secret revision of the kubeconfig of the namespace last stored successfully by the sink, always 1",
+	}, []string{"sink",
+		"gen_service_account_name",
+		"gen_target_secret_namespace",
+		"source_revision"})
+
+	// freshnessNamespaces are the namespaces with freshness series,
+	// the series of namespaces no longer processed are deleted by RetainFreshness.
+	freshnessNamespaces     = map[string]struct{}{}
+	freshnessNamespacesLock sync.Mutex
+)
+
+// RecordFreshness records a kubeconfig successfully stored by the sink.
+// The credential expiry is nil when the credential does not expire.
+func RecordFreshness(appConfig *configuration.Config, sink, namespace, sourceRevision string, credentialExpiry *time.Time) {
+	freshnessNamespacesLock.Lock()
+	defer freshnessNamespacesLock.Unlock()
+	freshnessNamespaces[namespace] = struct{}{}
+
+	lastSuccessTimestamp.WithLabelValues(
+		sink,
+		appConfig.ServiceAccountName,
+		namespace).Set(float64(time.Now().Unix()))
+
+	sourceRevisionInfo.DeletePartialMatch(prometheus.Labels{
+		"sink":                        sink,
+		"gen_target_secret_namespace": namespace,
+	})
+	sourceRevisionInfo.WithLabelValues(
+		sink,
+		appConfig.ServiceAccountName,
+		namespace,
+		sourceRevision).Set(1)
+
+	if credentialExpiry == nil {
+		credentialExpiryTimestamp.DeletePartialMatch(prometheus.Labels{
+			"gen_target_secret_namespace": namespace,
+		})
+		return
+	}
+	credentialExpiryTimestamp.WithLabelValues(
+		appConfig.ServiceAccountName,
+		namespace).Set(float64(credentialExpiry.Unix()))
+}
+
+// RetainFreshness deletes the freshness series of namespaces other than the active ones.
+func RetainFreshness(activeNamespaces []string) {
+	active := map[string]struct{}{}
+	for _, ns := range activeNamespaces {
+		active[ns] = struct{}{}
+	}
+	freshnessNamespacesLock.Lock()
+	defer freshnessNamespacesLock.Unlock()
+	for ns := range freshnessNamespaces {
+		if _, ok := active[ns]; ok {
+			continue
+		}
+		labels := prometheus.Labels{"gen_target_secret_namespace": ns}
+		lastSuccessTimestamp.DeletePartialMatch(labels)
+		credentialExpiryTimestamp.DeletePartialMatch(labels)
+		sourceRevisionInfo.DeletePartialMatch(labels)
+		delete(freshnessNamespaces, ns)
+	}
+}
@@ -10,8 +10,7 @@ var (
 	secretSuccessTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "proxy_kubeconfig_generator_success_total",
 		Help: "Proxy kubeconfig generator successful secret operation creation count",
-	}, []string{"app_revision",
-		"operation",
+	}, []string{"operation",
 		"gen_service_account_name",
 		"gen_source_secret_name",
 		"gen_source_secret_namespace",
@@ -21,51 +20,84 @@ var (
 	secretFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "proxy_kubeconfig_generator_failed_total",
 		Help: "Proxy kubeconfig generator failed secret operation count",
-	}, []string{"app_revision",
-		"operation",
+	}, []string{"operation",
 		"gen_service_account_name",
 		"gen_source_secret_name",
 		"gen_source_secret_namespace",
 		"gen_target_secret_name",
 		"gen_target_secret_namespace"})
 
-	generatorRunCount = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "proxy_kubeconfig_generator_runs_total",
-		Help: "Number of runs this generator executed",
-	}, []string{"app_revision"})
-
-	generatorNamespaceCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "proxy_kubeconfig_generator_namespaces_total",
-		Help: "Number of namespaces configured for processing, instant",
-	}, []string{"app_revision"})
-
-	latencyNamespacesLoad = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "proxy_kubeconfig_generator_namespaces_load_ms",
-		Help: "Kubernetes namespaces load latency in milliseconds",
-	}, []string{"app_revision"})
-
-	latencySourceSecretLoad = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "proxy_kubeconfig_generator_source_secret_load_ms",
-		Help: "Source secret Kuberenets API get call latency",
-	}, []string{"app_revision",
+	secretWriteConflictsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_write_conflicts_total",
+		Help: "Proxy kubeconfig generator secret write conflict count",
+	}, []string{"operation",
+		"gen_service_account_name",
 		"gen_source_secret_name",
-		"gen_source_secret_namespace"})
+		"gen_source_secret_namespace",
+		"gen_target_secret_name",
+		"gen_target_secret_namespace"})
 
-	latencyTargetSecretOperation = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "proxy_kubeconfig_generator_target_secret_operation_ms",
-		Help: "Target secret Kubernetes API operation call latency",
-	}, []string{"app_revision",
-		"operation",
+	secretWriteRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_write_retries_total",
+		Help: "Proxy kubeconfig generator secret write retry count",
+	}, []string{"operation",
 		"gen_service_account_name",
 		"gen_source_secret_name",
 		"gen_source_secret_namespace",
 		"gen_target_secret_name",
 		"gen_target_secret_namespace"})
+
+	secretNotOwnedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_not_owned_total",
+		Help: "Proxy kubeconfig generator count of target secrets left untouched because they are not owned by the generator",
+	}, []string{"gen_service_account_name",
+		"gen_source_secret_name",
+		"gen_source_secret_namespace",
+		"gen_target_secret_name",
+		"gen_target_secret_namespace"})
+
+	remoteSinkOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_remote_sink_operations_total",
+		Help: "Proxy kubeconfig generator remote cluster sink operation count",
+	}, []string{"destination",
+		"operation",
+		"result"})
+
+	namespaceResultsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_namespace_results_total",
+		Help: "Proxy kubeconfig generator count of namespace outcomes by sink, result and failure reason",
+	}, []string{"sink",
+		"gen_target_secret_namespace",
+		"result",
+		"reason"})
+
+	generatorRunCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_runs_total",
+		Help: "Number of runs this generator executed",
+	})
+
+	generatorNamespaceCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_kubeconfig_generator_namespaces_total",
+		Help: "Number of namespaces configured for processing, instant",
+	})
+
+	kubernetesRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_kubeconfig_generator_kubernetes_request_duration_seconds",
+		Help:    "Kubernetes API request duration in seconds",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"cluster",
+		"verb",
+		"resource"})
+
+	reconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_kubeconfig_generator_reconcile_duration_seconds",
+		Help:    "Duration of a run and of the reconciliation of a namespace in seconds",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"scope"})
 )
 
 func RecordCreateSuccess(appConfig *configuration.Config, namespace string) {
 	secretSuccessTotal.WithLabelValues(
-		configuration.AppRevision(),
 		"create",
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
@@ -76,7 +108,6 @@ func RecordCreateSuccess(appConfig *configuration.Config, namespace string) {
 
 func RecordCreateFailure(appConfig *configuration.Config, namespace string) {
 	secretFailedTotal.WithLabelValues(
-		configuration.AppRevision(),
 		"create",
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
@@ -87,7 +118,6 @@ func RecordCreateFailure(appConfig *configuration.Config, namespace string) {
 
 func RecordUpdateSuccess(appConfig *configuration.Config, namespace string) {
 	secretSuccessTotal.WithLabelValues(
-		configuration.AppRevision(),
 		"update",
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
@@ -98,7 +128,6 @@ func RecordUpdateSuccess(appConfig *configuration.Config, namespace string) {
 
 func RecordUpdateFailure(appConfig *configuration.Config, namespace string) {
 	secretFailedTotal.WithLabelValues(
-		configuration.AppRevision(),
 		"update",
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
@@ -107,46 +136,73 @@ func RecordUpdateFailure(appConfig *configuration.Config, namespace string) {
 		namespace).Inc()
 }
 
-func RecordNamespaceCount(count float64) {
-	generatorNamespaceCount.WithLabelValues(
-		configuration.AppRevision()).Set(count)
-}
-
-func RecordRunCount() {
-	generatorRunCount.WithLabelValues(
-		configuration.AppRevision()).Inc()
-}
-
-func RecordNamespaceLoadLatency(value float64) {
-	latencyNamespacesLoad.WithLabelValues(
-		configuration.AppRevision()).Observe(value)
-}
-
-func RecordSourceSecretLoadLatency(appConfig *configuration.Config, value float64) {
-	latencySourceSecretLoad.WithLabelValues(
-		configuration.AppRevision(),
+func RecordWriteConflict(appConfig *configuration.Config, operation, namespace string) {
+	secretWriteConflictsTotal.WithLabelValues(
+		operation,
+		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
-		appConfig.ServerTLSSecretNamespace).Observe(value)
+		appConfig.ServerTLSSecretNamespace,
+		appConfig.TenantSecretName(),
+		namespace).Inc()
 }
 
-func RecordTargetSecretCreateLatency(appConfig *configuration.Config, namespace string, value float64) {
-	latencyTargetSecretOperation.WithLabelValues(
-		configuration.AppRevision(),
-		"create",
+func RecordWriteRetry(appConfig *configuration.Config, operation, namespace string) {
+	secretWriteRetriesTotal.WithLabelValues(
+		operation,
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
 		appConfig.ServerTLSSecretNamespace,
 		appConfig.TenantSecretName(),
-		namespace).Observe(value)
+		namespace).Inc()
 }
 
-func RecordTargetSecretUpdateLatency(appConfig *configuration.Config, namespace string, value float64) {
-	latencyTargetSecretOperation.WithLabelValues(
-		configuration.AppRevision(),
-		"update",
+func RecordNotOwned(appConfig *configuration.Config, namespace string) {
+	secretNotOwnedTotal.WithLabelValues(
 		appConfig.ServiceAccountName,
 		appConfig.ServerTLSSecretName,
 		appConfig.ServerTLSSecretNamespace,
 		appConfig.TenantSecretName(),
-		namespace).Observe(value)
+		namespace).Inc()
+}
+
+func RecordRemoteSinkOperation(destination, operation string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	remoteSinkOperationsTotal.WithLabelValues(
+		destination,
+		operation,
+		result).Inc()
+}
+
+func RecordNamespaceResult(sink, namespace, result, reason string) {
+	namespaceResultsTotal.WithLabelValues(
+		sink,
+		namespace,
+		result,
+		reason).Inc()
+}
+
+func RecordNamespaceCount(count float64) {
+	generatorNamespaceCount.Set(count)
+}
+
+func RecordRunCount() {
+	generatorRunCount.Inc()
+}
+
+func RecordKubernetesRequestDuration(cluster, verb, resource string, seconds float64) {
+	kubernetesRequestDuration.WithLabelValues(
+		cluster,
+		verb,
+		resource).Observe(seconds)
+}
+
+func RecordRunDuration(seconds float64) {
+	reconcileDuration.WithLabelValues("run").Observe(seconds)
+}
+
+func RecordNamespaceDuration(seconds float64) {
+	reconcileDuration.WithLabelValues("namespace").Observe(seconds)
 }
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+)
+
+// NewPusher returns a pusher sending the default registry to the configured Pushgateway.
+func NewPusher(pushConfig *configuration.PushgatewayConfig) (*push.Pusher, error) {
+	if err := pushConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: pushConfig.InsecureSkipVerify}
+	if pushConfig.CACertFile != "" {
+		pem, err := os.ReadFile(pushConfig.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading pushgateway CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in pushgateway CA certificate file '%s'", pushConfig.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if pushConfig.ClientCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(pushConfig.ClientCertFile, pushConfig.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading pushgateway client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return NewPusherWithClient(pushConfig, Gatherer(), &http.Client{
+		Transport: transport,
+		Timeout:   pushConfig.Timeout,
+	})
+}
+
+// NewPusherWithClient returns a pusher sending the gatherer's metrics
+// to the configured Pushgateway with the given HTTP client.
+func NewPusherWithClient(pushConfig *configuration.PushgatewayConfig, gatherer prometheus.Gatherer, httpClient push.HTTPDoer) (*push.Pusher, error) {
+	pusher := push.New(pushConfig.URL, pushConfig.Job).
+		Gatherer(gatherer).
+		Client(httpClient)
+	for name, value := range pushConfig.Grouping.Map() {
+		pusher = pusher.Grouping(name, value)
+	}
+	if pushConfig.Username != "" {
+		password := ""
+		if pushConfig.PasswordFile != "" {
+			data, err := os.ReadFile(pushConfig.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed reading pushgateway password file: %w", err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		pusher = pusher.BasicAuth(pushConfig.Username, password)
+	}
+	return pusher, nil
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+)
+
+type pushRequest struct {
+	method   string
+	path     string
+	username string
+	password string
+	body     string
+}
+
+func newTestPushgateway(t *testing.T, status int) (*httptest.Server, *[]pushRequest) {
+	t.Helper()
+	requests := &[]pushRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		username, password, _ := r.BasicAuth()
+		*requests = append(*requests, pushRequest{
+			method:   r.Method,
+			path:     r.URL.Path,
+			username: username,
+			password: password,
+			body:     string(body),
+		})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newTestGatherer() prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_kubeconfig_generator_test_total",
+		Help: "Test counter.",
+	})
+	counter.Inc()
+	registry.MustRegister(counter)
+	return registry
+}
+
+func TestPusherPush(t *testing.T) {
+	server, requests := newTestPushgateway(t, http.StatusOK)
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pushConfig := &configuration.PushgatewayConfig{
+		URL:          server.URL,
+		Job:          "proxy-kubeconfig-generator",
+		Username:     "pusher",
+		PasswordFile: passwordFile,
+	}
+	if err := pushConfig.Grouping.Set("cluster=prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	pusher, err := NewPusherWithClient(pushConfig, newTestGatherer(), server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(*requests))
+	}
+	request := (*requests)[0]
+	if request.method != http.MethodPut {
+		t.Fatalf("expected a PUT request, got %s", request.method)
+	}
+	if request.path != "/metrics/job/proxy-kubeconfig-generator/cluster/prod" {
+		t.Fatalf("unexpected push path %s", request.path)
+	}
+	if request.username != "pusher" || request.password != "secret" {
+		t.Fatalf("unexpected basic auth credentials %s:%s", request.username, request.password)
+	}
+	if !strings.Contains(request.body, "proxy_kubeconfig_generator_test_total") {
+		t.Fatalf("expected the gathered metrics in the body")
+	}
+}
+
+func TestPusherPushFailure(t *testing.T) {
+	server, _ := newTestPushgateway(t, http.StatusInternalServerError)
+
+	pusher, err := NewPusherWithClient(&configuration.PushgatewayConfig{
+		URL: server.URL,
+		Job: "proxy-kubeconfig-generator",
+	}, newTestGatherer(), server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(); err == nil {
+		t.Fatalf("expected the push to fail")
+	}
+}
+
+func TestNewPusherMissingPasswordFile(t *testing.T) {
+	_, err := NewPusherWithClient(&configuration.PushgatewayConfig{
+		URL:          "http://localhost:9091",
+		Job:          "proxy-kubeconfig-generator",
+		Username:     "pusher",
+		PasswordFile: filepath.Join(t.TempDir(), "missing"),
+	}, newTestGatherer(), http.DefaultClient)
+	if err == nil {
+		t.Fatalf("expected a missing password file to fail")
+	}
+}
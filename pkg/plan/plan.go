@@ -0,0 +1,164 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Action is what the generator would do with the kubeconfig secret of a namespace.
+type Action string
+
+const (
+	// ActionCreate means the secret does not exist and would be created.
+	ActionCreate Action = "create"
+	// ActionUpdate means the secret exists and would be updated.
+	ActionUpdate Action = "update"
+	// ActionNone means the secret is up to date.
+	ActionNone Action = "none"
+	// ActionUpdatesDisabled means the secret exists but updates are disabled.
+	ActionUpdatesDisabled Action = "updates-disabled"
+	// ActionNotOwned means the secret exists but is not owned by the generator.
+	ActionNotOwned Action = "not-owned"
+	// ActionError means the plan could not be computed.
+	ActionError Action = "error"
+)
+
+// Change is a difference between the existing and the desired kubeconfig secret.
+// Secret values are only ever represented by their fingerprints.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Entry is the plan of a single namespace.
+type Entry struct {
+	Namespace  string   `json:"namespace"`
+	SecretName string   `json:"secretName"`
+	Action     Action   `json:"action"`
+	Changes    []Change `json:"changes,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Plan is the set of changes the generator would make.
+type Plan struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	Entries     []*Entry  `json:"entries"`
+}
+
+// New returns an empty plan.
+func New() *Plan {
+	return &Plan{
+		GeneratedAt: time.Now().UTC(),
+		Entries:     []*Entry{},
+	}
+}
+
+// Add adds a namespace entry to the plan.
+func (p *Plan) Add(entry *Entry) {
+	p.Entries = append(p.Entries, entry)
+	sort.Slice(p.Entries, func(i, j int) bool {
+		return p.Entries[i].Namespace < p.Entries[j].Namespace
+	})
+}
+
+// HasChanges returns true if applying the plan would modify anything.
+func (p *Plan) HasChanges() bool {
+	for _, entry := range p.Entries {
+		if entry.Action == ActionCreate || entry.Action == ActionUpdate {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteJSON writes the plan as indented JSON.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WriteTable writes the plan as a table with one row per change.
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tSECRET\tACTION\tFIELD\tOLD\tNEW")
+	for _, entry := range p.Entries {
+		if entry.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\t\t%s\n", entry.Namespace, entry.SecretName, entry.Action, entry.Error)
+			continue
+		}
+		if len(entry.Changes) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\t\t\n", entry.Namespace, entry.SecretName, entry.Action)
+			continue
+		}
+		for _, change := range entry.Changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Namespace, entry.SecretName, entry.Action,
+				change.Field, valueOrDash(change.Old), valueOrDash(change.New))
+		}
+	}
+	return tw.Flush()
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Fingerprint returns a short SHA-256 fingerprint of secret data,
+// an empty string for empty data.
+func Fingerprint(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])[0:16]
+}
+
+// Store holds the most recently computed plan.
+type Store struct {
+	sync.RWMutex
+	plan *Plan
+}
+
+// NewStore returns an empty plan store.
+func NewStore() *Store {
+	return &Store{}
+}
+
+// Set replaces the stored plan.
+func (s *Store) Set(p *Plan) {
+	s.Lock()
+	defer s.Unlock()
+	s.plan = p
+}
+
+// Get returns the stored plan, nil if no plan was computed yet.
+func (s *Store) Get() *Plan {
+	s.RLock()
+	defer s.RUnlock()
+	return s.plan
+}
+
+// Handler serves the stored plan as JSON.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := s.Get()
+		if current == nil {
+			http.Error(w, "no plan computed yet, plans are computed in report only mode", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		current.WriteJSON(w)
+	})
+}
@@ -0,0 +1,182 @@
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ScopeStrict binds the sealed secret to its name and namespace.
+	ScopeStrict = "strict"
+	// ScopeNamespaceWide allows renaming the sealed secret within its namespace.
+	ScopeNamespaceWide = "namespace-wide"
+	// ScopeClusterWide allows unsealing the secret in any namespace under any name.
+	ScopeClusterWide = "cluster-wide"
+
+	// AnnotationNamespaceWide marks namespace-wide sealed secrets.
+	AnnotationNamespaceWide = "sealedsecrets.bitnami.com/namespace-wide"
+	// AnnotationClusterWide marks cluster-wide sealed secrets.
+	AnnotationClusterWide = "sealedsecrets.bitnami.com/cluster-wide"
+
+	sessionKeyBytes = 32
+)
+
+// Resource is the SealedSecret resource.
+var Resource = schema.GroupVersionResource{
+	Group:    "bitnami.com",
+	Version:  "v1alpha1",
+	Resource: "sealedsecrets",
+}
+
+// ValidateScope returns an error if the scope is not known.
+func ValidateScope(scope string) error {
+	switch scope {
+	case ScopeStrict, ScopeNamespaceWide, ScopeClusterWide:
+		return nil
+	}
+	return fmt.Errorf("unknown sealed secret scope '%s'", scope)
+}
+
+// ParsePublicKey parses the RSA public key from a PEM encoded sealing certificate.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported sealing certificate public key type %T, only RSA keys are supported", certificate.PublicKey)
+	}
+	return publicKey, nil
+}
+
+// Settings returns the scope and the fingerprint of the sealing public key,
+// a sealed secret has to be sealed again when either changes.
+func Settings(scope string, publicKey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := sha256.Sum256(der)
+	return fmt.Sprintf("%s/sha256:%s", scope, hex.EncodeToString(fingerprint[:])), nil
+}
+
+// Label returns the encryption label binding the ciphertext to the scope.
+func Label(scope, namespace, name string) []byte {
+	switch scope {
+	case ScopeClusterWide:
+		return []byte{}
+	case ScopeNamespaceWide:
+		return []byte(namespace)
+	}
+	return []byte(fmt.Sprintf("%s/%s", namespace, name))
+}
+
+// Annotations returns the annotations marking the scope of a sealed secret.
+func Annotations(scope string) map[string]interface{} {
+	switch scope {
+	case ScopeClusterWide:
+		return map[string]interface{}{AnnotationClusterWide: "true"}
+	case ScopeNamespaceWide:
+		return map[string]interface{}{AnnotationNamespaceWide: "true"}
+	}
+	return map[string]interface{}{}
+}
+
+// HybridEncrypt encrypts the plaintext the way the sealed secrets controller expects:
+// a random AES-256-GCM session key is encrypted with RSA-OAEP and prepended
+// to the ciphertext together with its length.
+func HybridEncrypt(rnd io.Reader, publicKey *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, publicKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2)
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+
+	// The session key is used only once so a zero nonce is safe.
+	zeroNonce := make([]byte, aead.NonceSize())
+	return aead.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
+
+// New returns a SealedSecret object with every data value encrypted for the scope.
+// Labels and annotations are set on the SealedSecret and on the template of the unsealed secret.
+func New(publicKey *rsa.PublicKey, scope, namespace, name string, labels, annotations map[string]string, data map[string][]byte) (*unstructured.Unstructured, error) {
+	label := Label(scope, namespace, name)
+	encryptedData := map[string]interface{}{}
+	for key, value := range data {
+		ciphertext, err := HybridEncrypt(rand.Reader, publicKey, value, label)
+		if err != nil {
+			return nil, fmt.Errorf("failed encrypting key '%s': %w", key, err)
+		}
+		encryptedData[key] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	objectLabels := map[string]interface{}{}
+	for key, value := range labels {
+		objectLabels[key] = value
+	}
+
+	objectAnnotations := Annotations(scope)
+	for key, value := range annotations {
+		objectAnnotations[key] = value
+	}
+
+	metadata := map[string]interface{}{
+		"name":      name,
+		"namespace": namespace,
+		"labels":    objectLabels,
+	}
+	if len(objectAnnotations) > 0 {
+		metadata["annotations"] = objectAnnotations
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": Resource.GroupVersion().String(),
+			"kind":       "SealedSecret",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"encryptedData": encryptedData,
+				"template": map[string]interface{}{
+					"metadata": runtime.DeepCopyJSONValue(metadata),
+					"type":     "Opaque",
+				},
+			},
+		},
+	}, nil
+}
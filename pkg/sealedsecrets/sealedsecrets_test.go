@@ -0,0 +1,210 @@
+package sealedsecrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// hybridDecrypt decrypts the ciphertext the way the sealed secrets controller does.
+func hybridDecrypt(privateKey *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	if len(ciphertext) < 2 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	rsaLength := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < rsaLength+2 {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, ciphertext[2:rsaLength+2], label)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	zeroNonce := make([]byte, aead.NonceSize())
+	return aead.Open(nil, zeroNonce, ciphertext[rsaLength+2:], nil)
+}
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+func newTestCertificate(t *testing.T, privateKey *rsa.PrivateKey) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestHybridEncryptRoundTrip(t *testing.T) {
+	privateKey := newTestKey(t)
+	plaintext := []byte("apiVersion: v1\nkind: Config\n")
+
+	ciphertext, err := HybridEncrypt(rand.Reader, &privateKey.PublicKey, plaintext, Label(ScopeStrict, "team-a", "tenant-kubeconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := hybridDecrypt(privateKey, ciphertext, Label(ScopeStrict, "team-a", "tenant-kubeconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != string(plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, decrypted)
+	}
+
+	if _, err := hybridDecrypt(privateKey, ciphertext, Label(ScopeStrict, "team-b", "tenant-kubeconfig")); err == nil {
+		t.Fatalf("expected a strict secret not to decrypt in another namespace")
+	}
+}
+
+func TestLabel(t *testing.T) {
+	for _, tc := range []struct {
+		scope    string
+		expected string
+	}{
+		{ScopeStrict, "team-a/tenant-kubeconfig"},
+		{ScopeNamespaceWide, "team-a"},
+		{ScopeClusterWide, ""},
+	} {
+		if label := string(Label(tc.scope, "team-a", "tenant-kubeconfig")); label != tc.expected {
+			t.Fatalf("expected label %q for scope %s, got %q", tc.expected, tc.scope, label)
+		}
+	}
+}
+
+func TestNewRoundTrip(t *testing.T) {
+	privateKey := newTestKey(t)
+	for _, scope := range []string{ScopeStrict, ScopeNamespaceWide, ScopeClusterWide} {
+		sealedSecret, err := New(&privateKey.PublicKey, scope, "team-a", "tenant-kubeconfig",
+			map[string]string{"app.kubernetes.io/managed-by": "proxy-kubeconfig-generator"},
+			map[string]string{"proxy-kubeconfig-generator/sealing-settings": "settings"},
+			map[string][]byte{"kubeconfig": []byte("kubeconfig data")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, found, err := unstructured.NestedString(sealedSecret.Object, "spec", "encryptedData", "kubeconfig")
+		if err != nil || !found {
+			t.Fatalf("expected encrypted kubeconfig data for scope %s: %v", scope, err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := hybridDecrypt(privateKey, ciphertext, Label(scope, "team-a", "tenant-kubeconfig"))
+		if err != nil {
+			t.Fatalf("failed decrypting secret with scope %s: %v", scope, err)
+		}
+		if string(decrypted) != "kubeconfig data" {
+			t.Fatalf("unexpected data for scope %s: %q", scope, decrypted)
+		}
+
+		for _, path := range [][]string{{"metadata"}, {"spec", "template", "metadata"}} {
+			annotations, _, _ := unstructured.NestedStringMap(sealedSecret.Object, append(path, "annotations")...)
+			if annotations["proxy-kubeconfig-generator/sealing-settings"] != "settings" {
+				t.Fatalf("expected the annotation on %v for scope %s, got %v", path, scope, annotations)
+			}
+			for key, value := range Annotations(scope) {
+				if annotations[key] != value {
+					t.Fatalf("expected the scope annotation on %v for scope %s, got %v", path, scope, annotations)
+				}
+			}
+			labels, _, _ := unstructured.NestedStringMap(sealedSecret.Object, append(path, "labels")...)
+			if labels["app.kubernetes.io/managed-by"] != "proxy-kubeconfig-generator" {
+				t.Fatalf("expected the label on %v for scope %s, got %v", path, scope, labels)
+			}
+		}
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	privateKey := newTestKey(t)
+	publicKey, err := ParsePublicKey(newTestCertificate(t, privateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKey.Equal(&privateKey.PublicKey) {
+		t.Fatalf("expected the certificate public key")
+	}
+
+	if _, err := ParsePublicKey([]byte("not a certificate")); err == nil {
+		t.Fatalf("expected invalid data to fail")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	privateKey := newTestKey(t)
+	rotatedKey := newTestKey(t)
+
+	settings, err := Settings(ScopeStrict, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []struct {
+		scope     string
+		publicKey *rsa.PublicKey
+	}{
+		{ScopeNamespaceWide, &privateKey.PublicKey},
+		{ScopeStrict, &rotatedKey.PublicKey},
+	} {
+		otherSettings, err := Settings(other.scope, other.publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if otherSettings == settings {
+			t.Fatalf("expected settings to change with the scope and the key")
+		}
+	}
+
+	sameSettings, err := Settings(ScopeStrict, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameSettings != settings {
+		t.Fatalf("expected stable settings, got %s and %s", settings, sameSettings)
+	}
+}
+
+func TestValidateScope(t *testing.T) {
+	for _, scope := range []string{ScopeStrict, ScopeNamespaceWide, ScopeClusterWide} {
+		if err := ValidateScope(scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ValidateScope("global"); err == nil {
+		t.Fatalf("expected an unknown scope to fail")
+	}
+}
@@ -2,23 +2,31 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
 	"github.com/radekg/proxy-kubeconfig-generator/pkg/utils"
-
-	// import metrics so that counters are initialized regardless
-	_ "github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
 )
 
+// closeTimeout is how long Close waits for active requests to finish.
+const closeTimeout = time.Second * 5
+
 // Runner represents a snapshot coordinator runner.
 type Runner interface {
 	Close()
+	Handle(pattern string, handler http.Handler)
+	Shutdown(ctx context.Context) error
 	Start(appConfig *configuration.HttpConfig) utils.StartStatus
+	WrapMetrics(wrapper func(http.Handler) http.Handler)
 }
 
 // NewDefaultRunner returns an unconfigured and not started
@@ -26,62 +34,159 @@ type Runner interface {
 func NewDefaultRunner(logger hclog.Logger) Runner {
 	return &defaultRunner{
 		logger: logger,
+		mux:    http.NewServeMux(),
 	}
 }
 
 type defaultRunner struct {
+	sync.Mutex
+
 	logger hclog.Logger
+	mux    *http.ServeMux
 
-	ctxCancelFunc context.CancelFunc
+	httpServer       *http.Server
+	listener         net.Listener
+	routesRegistered bool
+	metricsWrapper   func(http.Handler) http.Handler
 }
 
+// Close shuts the server down, waiting a short time for active requests to finish.
 func (r *defaultRunner) Close() {
-	if r.ctxCancelFunc != nil {
-		r.ctxCancelFunc()
-		r.ctxCancelFunc = nil
+	ctx, cancelFunc := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancelFunc()
+	if err := r.Shutdown(ctx); err != nil {
+		r.logger.Warn("Server did not shut down gracefully", "reason", err)
 	}
 }
 
-// Start starts this server instance.
+// Handle mounts an additional handler on the server. Handlers can be mounted
+// before or after the server is started.
+func (r *defaultRunner) Handle(pattern string, handler http.Handler) {
+	r.mux.Handle(pattern, handler)
+}
+
+// WrapMetrics protects the metrics endpoint with the wrapper. Requests with a verified
+// client certificate bypass the wrapper. Must be called before the server is started.
+func (r *defaultRunner) WrapMetrics(wrapper func(http.Handler) http.Handler) {
+	r.Lock()
+	defer r.Unlock()
+	r.metricsWrapper = wrapper
+}
+
+// Shutdown stops accepting new connections and waits for the active requests
+// to finish until the context is done, then closes the remaining connections.
+func (r *defaultRunner) Shutdown(ctx context.Context) error {
+	r.Lock()
+	httpServer := r.httpServer
+	listener := r.listener
+	r.httpServer = nil
+	r.listener = nil
+	r.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	err := httpServer.Shutdown(ctx)
+	// Serve may not have picked the listener up yet, make sure the address is released.
+	listener.Close()
+	if err != nil {
+		httpServer.Close()
+		return err
+	}
+	r.logger.Info("Server stopped")
+	return nil
+}
+
+// Start starts this server instance. The start is reported once the listener is bound.
 func (r *defaultRunner) Start(appConfig *configuration.HttpConfig) utils.StartStatus {
 
 	status := utils.NewDefaultStartStatus()
 
-	if r.ctxCancelFunc != nil {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.httpServer != nil {
 		status.ReportError(errors.ErrServerAlreadyRunning)
 		return status
 	}
 
-	_, cancelFunc := context.WithCancel(context.Background())
-	r.ctxCancelFunc = cancelFunc
-
-	go func() {
-		r.logger.Info("Starting server with bind address",
-			"address", appConfig.MetricsBindHostPort)
-		chanListenErr := make(chan error, 1)
+	r.logger.Info("Starting server with bind address",
+		"address", appConfig.MetricsBindHostPort)
 
-		http.HandleFunc(appConfig.URIPathHealth, func(w http.ResponseWriter, r *http.Request) {
+	// The mux outlives the server, a restarted runner keeps its routes.
+	if !r.routesRegistered {
+		r.mux.HandleFunc(appConfig.URIPathHealth, func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 		})
-		http.Handle(appConfig.URIPathMetrics, promhttp.Handler())
+		r.mux.Handle(appConfig.URIPathMetrics, r.metricsHandler(appConfig))
+		r.routesRegistered = true
+	}
 
-		go func() {
-			r.logger.Info("Starting server without TLS")
-			if err := http.ListenAndServe(appConfig.MetricsBindHostPort, nil); err != nil {
-				chanListenErr <- err
-			}
-
-		}()
-		select {
-		case err := <-chanListenErr:
-			r.logger.Error("Server failed to start", "reason", err)
+	var tlsConfig *tls.Config
+	if appConfig.TLSEnabled() {
+		reloader, err := newTLSReloader(appConfig, r.logger)
+		if err != nil {
+			r.logger.Error("Server failed to load TLS files", "reason", err)
 			status.ReportError(err)
-		case <-time.After(time.Millisecond * 500):
-			r.logger.Info("Server running and serving",
-				"bind-host-port", appConfig.MetricsBindHostPort)
-			status.ReportSuccess()
+			return status
+		}
+		tlsConfig = reloader.TLSConfig()
+	}
+
+	listener, err := net.Listen("tcp", appConfig.MetricsBindHostPort)
+	if err != nil {
+		r.logger.Error("Server failed to start", "reason", err)
+		status.ReportError(err)
+		return status
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	httpServer := &http.Server{Handler: r.mux}
+	r.httpServer = httpServer
+	r.listener = listener
+
+	go func() {
+		if tlsConfig != nil {
+			r.logger.Info("Starting server with TLS",
+				"cert-file", appConfig.TLSCertFile,
+				"client-ca-file", appConfig.TLSClientCAFile)
+		} else {
+			r.logger.Info("Starting server without TLS")
+		}
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			r.logger.Error("Server stopped serving", "reason", err)
 		}
 	}()
 
+	r.logger.Info("Server running and serving",
+		"bind-host-port", listener.Addr().String())
+	status.ReportSuccess()
+
 	return status
 }
+
+// metricsHandler returns the metrics handler. Requests with a verified client certificate
+// are served, other requests go through the metrics wrapper. Without a wrapper, a client
+// certificate is required when a client CA is configured.
+func (r *defaultRunner) metricsHandler(appConfig *configuration.HttpConfig) http.Handler {
+	handler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(metrics.Gatherer(), promhttp.HandlerOpts{}))
+	if r.metricsWrapper == nil && appConfig.TLSClientCAFile == "" {
+		return handler
+	}
+	protected := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+	})
+	if r.metricsWrapper != nil {
+		protected = r.metricsWrapper(handler).ServeHTTP
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		protected.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+)
+
+// tlsReloader serves the certificate and the client CA from files and reloads them
+// when the files change, so rotated certificates are picked up without a restart.
+type tlsReloader struct {
+	sync.Mutex
+
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	logger       hclog.Logger
+
+	modTimes []time.Time
+	config   *tls.Config
+}
+
+func newTLSReloader(httpConfig *configuration.HttpConfig, logger hclog.Logger) (*tlsReloader, error) {
+	reloader := &tlsReloader{
+		certFile:     httpConfig.TLSCertFile,
+		keyFile:      httpConfig.TLSKeyFile,
+		clientCAFile: httpConfig.TLSClientCAFile,
+		logger:       logger,
+	}
+	modTimes, err := reloader.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	config, err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	reloader.modTimes = modTimes
+	reloader.config = config
+	return reloader, nil
+}
+
+// TLSConfig returns the server TLS configuration. Every handshake uses the latest loaded files.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+// current returns the configuration, reloaded first when any of the files changed.
+// A failed reload is logged and the previous configuration stays in use.
+func (r *tlsReloader) current() *tls.Config {
+	r.Lock()
+	defer r.Unlock()
+
+	modTimes, err := r.statFiles()
+	if err != nil {
+		r.logger.Warn("Failed checking TLS files, keeping the loaded certificate", "reason", err)
+		return r.config
+	}
+	if timesEqual(modTimes, r.modTimes) {
+		return r.config
+	}
+	config, err := r.load()
+	if err != nil {
+		// A rotation can write the certificate and the key one after the other,
+		// the modification times are kept so the load is retried on the next handshake.
+		r.logger.Warn("Failed reloading TLS files, keeping the loaded certificate", "reason", err)
+		return r.config
+	}
+	r.modTimes = modTimes
+	r.config = config
+	r.logger.Info("Reloaded TLS files",
+		"cert-file", r.certFile,
+		"client-ca-file", r.clientCAFile)
+	return r.config
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *tlsReloader) statFiles() ([]time.Time, error) {
+	modTimes := []time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if r.clientCAFile != "" {
+		clientCAData, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading client CA: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(clientCAData) {
+			return nil, fmt.Errorf("no certificates found in client CA file '%s'", r.clientCAFile)
+		}
+		config.ClientCAs = clientCAs
+		// Probes and Prometheus without a client certificate must still reach the health endpoints,
+		// client certificates are required per endpoint.
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+func timesEqual(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,334 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// fileSinkMarkerExtension is the name of the kubeconfig extension marking files written by the file sink.
+const fileSinkMarkerExtension = "proxy-kubeconfig-generator"
+
+// fileSinkMarker identifies the generator instance which wrote a kubeconfig file.
+type fileSinkMarker struct {
+	ManagedBy          string `json:"managedBy"`
+	SourceCluster      string `json:"sourceCluster,omitempty"`
+	ServiceAccountName string `json:"serviceAccountName"`
+	Namespace          string `json:"namespace"`
+}
+
+// NewFileSink returns a sink writing the kubeconfig to a file.
+func NewFileSink(fileConfig *configuration.FileSinkConfig, opArgs k8s.OperationArgs) (Sink, error) {
+	if err := fileConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if opArgs.AppConfig().EncryptionEnabled() {
+		return nil, fmt.Errorf("the file sink does not support encryption")
+	}
+	pathTemplate, err := template.New("file-path").Parse(fileConfig.PathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path template: %w", err)
+	}
+	sink := &fileSink{
+		fileConfig:   fileConfig,
+		pathTemplate: pathTemplate,
+		opArgs:       opArgs,
+	}
+	pruneEnabled, err := sink.pathIncludesServiceAccount()
+	if err != nil {
+		return nil, fmt.Errorf("invalid file path template: %w", err)
+	}
+	if !pruneEnabled {
+		opArgs.Logger().Warn("File path template does not include the service account name, stale files will not be pruned",
+			"path-template", fileConfig.PathTemplate)
+	}
+	sink.pruneEnabled = pruneEnabled
+	return sink, nil
+}
+
+type fileSink struct {
+	fileConfig   *configuration.FileSinkConfig
+	pathTemplate *template.Template
+	opArgs       k8s.OperationArgs
+	pruneEnabled bool
+}
+
+func (s *fileSink) Name() string {
+	return SinkFile
+}
+
+func (s *fileSink) Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	logger := tracing.Logger(ctx, s.opArgs.Logger())
+
+	path, err := s.path(targetNamespace)
+	if err != nil {
+		logger.Error("Failed rendering file path",
+			"namespace", targetNamespace,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	configBuffer, err := s.serialize(targetNamespace, kubeconfig)
+	if err != nil {
+		logger.Error("Failed serializing kubeconfig to buffer",
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("Failed reading existing kubeconfig file",
+			"namespace", targetNamespace,
+			"path", path,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+	hasExistingFile := err == nil
+
+	fileMode := os.FileMode(s.fileConfig.FileMode)
+	if hasExistingFile {
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Error("Failed checking existing kubeconfig file mode",
+				"namespace", targetNamespace,
+				"path", path,
+				"reason", err)
+			return k8s.ResultFailed, err
+		}
+		if s.opArgs.AppConfig().DisallowUpdates {
+			logger.Info("File exists and updates are disabled",
+				"namespace", targetNamespace,
+				"path", path)
+			return k8s.ResultSkipped, nil
+		}
+		// A changed file mode rewrites the file, so that the new mode reaches existing files.
+		if bytes.Equal(existing, configBuffer) && info.Mode().Perm() == fileMode {
+			logger.Info("Nothing to do, file already contains the current kubeconfig",
+				"namespace", targetNamespace,
+				"path", path,
+				"source-secret-resource-version", k8s.SourceResourceRevision(sourceSecret))
+			return k8s.ResultUnchanged, nil
+		}
+	}
+
+	if s.opArgs.AppConfig().ReportOnly {
+		logger.Info("Report only: would write a file",
+			"namespace", targetNamespace,
+			"path", path,
+			"file-exists", hasExistingFile,
+			"source-secret-resource-version", k8s.SourceResourceRevision(sourceSecret),
+			"file-size", len(configBuffer))
+		return k8s.ResultReportOnly, nil
+	}
+
+	if err := writeFileAtomic(path, configBuffer, fileMode); err != nil {
+		logger.Error("Failed writing kubeconfig file",
+			"namespace", targetNamespace,
+			"path", path,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	result := k8s.ResultCreated
+	if hasExistingFile {
+		result = k8s.ResultUpdated
+	}
+
+	logger.Info("Kubeconfig file written",
+		"namespace", targetNamespace,
+		"path", path,
+		"file-mode", s.fileConfig.FileMode.String(),
+		"source-secret-resource-version", k8s.SourceResourceRevision(sourceSecret))
+
+	return result, nil
+}
+
+// Prune removes kubeconfig files of namespaces no longer processed.
+// Candidate files are found by rendering the path template with a wildcard
+// namespace and only files carrying the marker of this generator instance are removed.
+// Nothing is pruned when the path template does not include the service account name,
+// files of other service accounts could match the pattern.
+func (s *fileSink) Prune(ctx context.Context, activeNamespaces []string) error {
+
+	if !s.pruneEnabled {
+		s.opArgs.Logger().Debug("Not pruning, file path template does not include the service account name")
+		return nil
+	}
+
+	active := map[string]struct{}{}
+	for _, ns := range activeNamespaces {
+		path, err := s.path(ns)
+		if err != nil {
+			s.opArgs.Logger().Error("Failed rendering file path",
+				"namespace", ns,
+				"reason", err)
+			return err
+		}
+		active[path] = struct{}{}
+	}
+
+	pattern, err := s.path("*")
+	if err != nil {
+		s.opArgs.Logger().Error("Failed rendering file path pattern",
+			"reason", err)
+		return err
+	}
+
+	candidates, err := filepath.Glob(pattern)
+	if err != nil {
+		s.opArgs.Logger().Error("Failed listing kubeconfig files",
+			"pattern", pattern,
+			"reason", err)
+		return err
+	}
+
+	var lastErr error
+	for _, candidate := range candidates {
+		if _, ok := active[candidate]; ok {
+			continue
+		}
+		if !s.isGeneratedFile(candidate) {
+			continue
+		}
+		if s.opArgs.AppConfig().ReportOnly {
+			s.opArgs.Logger().Info("Report only: would remove a stale file",
+				"path", candidate)
+			continue
+		}
+		if err := os.Remove(candidate); err != nil && !os.IsNotExist(err) {
+			s.opArgs.Logger().Error("Failed removing stale kubeconfig file",
+				"path", candidate,
+				"reason", err)
+			lastErr = err
+			continue
+		}
+		s.opArgs.Logger().Info("Stale kubeconfig file removed",
+			"path", candidate)
+	}
+
+	return lastErr
+}
+
+// serialize writes the kubeconfig with the marker extension identifying this generator instance.
+func (s *fileSink) serialize(targetNamespace string, kubeconfig *clientcmdapi.Config) ([]byte, error) {
+	marker, err := json.Marshal(s.marker(targetNamespace))
+	if err != nil {
+		return nil, err
+	}
+	config := kubeconfig.DeepCopy()
+	if config.Extensions == nil {
+		config.Extensions = map[string]runtime.Object{}
+	}
+	config.Extensions[fileSinkMarkerExtension] = &runtime.Unknown{
+		Raw:         marker,
+		ContentType: runtime.ContentTypeJSON,
+	}
+	return clientcmd.Write(*config)
+}
+
+func (s *fileSink) marker(targetNamespace string) fileSinkMarker {
+	return fileSinkMarker{
+		ManagedBy:          configuration.OwnershipLabelValue,
+		SourceCluster:      s.opArgs.AppConfig().SourceClusterName,
+		ServiceAccountName: s.opArgs.AppConfig().ServiceAccountName,
+		Namespace:          targetNamespace,
+	}
+}
+
+// isGeneratedFile returns true if the file carries the marker of this generator instance
+// and its path is the one rendered for the namespace recorded in the marker.
+func (s *fileSink) isGeneratedFile(path string) bool {
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return false
+	}
+	extension, ok := config.Extensions[fileSinkMarkerExtension].(*runtime.Unknown)
+	if !ok {
+		return false
+	}
+	marker := fileSinkMarker{}
+	if err := json.Unmarshal(extension.Raw, &marker); err != nil {
+		return false
+	}
+	if marker != s.marker(marker.Namespace) {
+		return false
+	}
+	expectedPath, err := s.path(marker.Namespace)
+	if err != nil {
+		return false
+	}
+	return expectedPath == path
+}
+
+// pathIncludesServiceAccount returns true if the path template renders
+// different paths for different service accounts.
+func (s *fileSink) pathIncludesServiceAccount() (bool, error) {
+	paths := map[string]struct{}{}
+	for _, serviceAccountName := range []string{"sa-a", "sa-b"} {
+		buf := &bytes.Buffer{}
+		if err := s.pathTemplate.Execute(buf, &PathTemplateData{
+			Namespace:          "namespace",
+			ServiceAccountName: serviceAccountName,
+			SecretName:         (&configuration.Config{ServiceAccountName: serviceAccountName}).TenantSecretName(),
+		}); err != nil {
+			return false, err
+		}
+		paths[filepath.Clean(buf.String())] = struct{}{}
+	}
+	return len(paths) > 1, nil
+}
+
+func (s *fileSink) path(targetNamespace string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := s.pathTemplate.Execute(buf, &PathTemplateData{
+		Namespace:          targetNamespace,
+		ServiceAccountName: s.opArgs.AppConfig().ServiceAccountName,
+		SecretName:         s.opArgs.AppConfig().TenantSecretName(),
+	}); err != nil {
+		return "", err
+	}
+	return filepath.Clean(buf.String()), nil
+}
+
+// writeFileAtomic writes data to a temporary file in the target directory
+// and renames it over the target path.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf(".%s.*.tmp", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,169 @@
+package sinks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+)
+
+func newTestFileSink(t *testing.T, pathTemplate string, fileMode os.FileMode, appConfig *configuration.Config) Sink {
+	t.Helper()
+	sink, err := NewFileSink(&configuration.FileSinkConfig{
+		PathTemplate: pathTemplate,
+		FileMode:     configuration.FileMode(fileMode),
+	}, k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "kubeconfig")
+
+	if err := writeFileAtomic(path, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Fatalf("expected the replaced content, got %q", data)
+	}
+	if mode := fileMode(t, path); mode != 0o640 {
+		t.Fatalf("expected mode 0640, got %#o", mode)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected no temporary files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestFileSinkStore(t *testing.T) {
+	dir := t.TempDir()
+	sink := newTestFileSink(t, filepath.Join(dir, "{{ .Namespace }}", "{{ .ServiceAccountName }}.kubeconfig"), 0o600, newTestAppConfig())
+	path := filepath.Join(dir, "team-a", "tenant.kubeconfig")
+	ctx := context.Background()
+
+	result, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultCreated {
+		t.Fatalf("expected created, got %s: %v", result, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "https://proxy.example.com") {
+		t.Fatalf("expected the kubeconfig, got %s", data)
+	}
+	if mode := fileMode(t, path); mode != 0o600 {
+		t.Fatalf("expected mode 0600, got %#o", mode)
+	}
+
+	result, err = sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultUnchanged {
+		t.Fatalf("expected unchanged, got %s: %v", result, err)
+	}
+}
+
+func TestFileSinkStoreFileModeChanged(t *testing.T) {
+	dir := t.TempDir()
+	pathTemplate := filepath.Join(dir, "{{ .Namespace }}", "{{ .ServiceAccountName }}.kubeconfig")
+	path := filepath.Join(dir, "team-a", "tenant.kubeconfig")
+	ctx := context.Background()
+
+	sink := newTestFileSink(t, pathTemplate, 0o644, newTestAppConfig())
+	if _, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink = newTestFileSink(t, pathTemplate, 0o600, newTestAppConfig())
+	result, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultUpdated {
+		t.Fatalf("expected updated, got %s: %v", result, err)
+	}
+	if mode := fileMode(t, path); mode != 0o600 {
+		t.Fatalf("expected mode 0600, got %#o", mode)
+	}
+}
+
+func TestFileSinkPrune(t *testing.T) {
+	dir := t.TempDir()
+	pathTemplate := filepath.Join(dir, "{{ .Namespace }}", "{{ .ServiceAccountName }}.kubeconfig")
+	sink := newTestFileSink(t, pathTemplate, 0o600, newTestAppConfig())
+	ctx := context.Background()
+
+	for _, ns := range []string{"team-a", "team-b"} {
+		if _, err := sink.Store(ctx, ns, newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Written by hand, without the marker.
+	unmarked := filepath.Join(dir, "team-c", "tenant.kubeconfig")
+	if err := writeFileAtomic(unmarked, []byte("apiVersion: v1\nkind: Config\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Written by the generator of another source cluster.
+	otherAppConfig := newTestAppConfig()
+	otherAppConfig.SourceClusterName = "other"
+	otherSink := newTestFileSink(t, pathTemplate, 0o600, otherAppConfig)
+	if _, err := otherSink.Store(ctx, "team-d", newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.(Pruner).Prune(ctx, []string{"team-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for ns, expected := range map[string]bool{
+		"team-a": true,  // still processed
+		"team-b": false, // no longer processed
+		"team-c": true,  // not marked
+		"team-d": true,  // marked by another instance
+	} {
+		_, err := os.Stat(filepath.Join(dir, ns, "tenant.kubeconfig"))
+		if exists := err == nil; exists != expected {
+			t.Fatalf("expected the file of namespace '%s' to exist: %v, got: %v", ns, expected, exists)
+		}
+	}
+}
+
+func TestFileSinkPruneDisabled(t *testing.T) {
+	dir := t.TempDir()
+	// Files of other service accounts would match the pattern.
+	sink := newTestFileSink(t, filepath.Join(dir, "{{ .Namespace }}.kubeconfig"), 0o600, newTestAppConfig())
+	ctx := context.Background()
+
+	if _, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.(Pruner).Prune(ctx, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team-a.kubeconfig")); err != nil {
+		t.Fatalf("expected the file to be kept: %v", err)
+	}
+}
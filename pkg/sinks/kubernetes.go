@@ -0,0 +1,47 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// NewKubernetesSecretSink returns a sink storing the kubeconfig in a secret
+// in the target namespace.
+func NewKubernetesSecretSink(opArgs k8s.OperationArgs) Sink {
+	return &kubernetesSecretSink{
+		opArgs: opArgs,
+	}
+}
+
+type kubernetesSecretSink struct {
+	opArgs k8s.OperationArgs
+}
+
+func (s *kubernetesSecretSink) Name() string {
+	return SinkKubernetes
+}
+
+func (s *kubernetesSecretSink) Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	opArgs := k8s.WithTraceLogger(ctx, s.opArgs)
+	result, err := k8s.CreateOrUpdateKubeConfigSecret(ctx, targetNamespace, opArgs, kubeconfig, sourceSecret)
+	if err != nil { // Logging taken care of.
+		return k8s.ResultFailed, err
+	}
+	// Skipped writes may not have created the secret, Flux objects are left alone.
+	if opArgs.AppConfig().FluxObjectLabelSelector != "" && result != k8s.ResultSkipped && result != k8s.ResultReportOnly {
+		secretName := opArgs.AppConfig().TenantSecretName()
+		if opArgs.AppConfig().ImmutableSecrets {
+			secretName, err = k8s.CurrentImmutableSecretName(ctx, targetNamespace, opArgs)
+			if err != nil { // Logging taken care of.
+				return k8s.ResultFailed, err
+			}
+		}
+		if err := k8s.PatchFluxObjects(ctx, targetNamespace, secretName, opArgs); err != nil { // Logging taken care of.
+			return k8s.ResultFailed, err
+		}
+	}
+	return result, nil
+}
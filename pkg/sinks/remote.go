@@ -0,0 +1,272 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// RemoteDestination is a remote cluster the kubeconfig secrets are mirrored to.
+type RemoteDestination struct {
+	Name              string
+	NamespaceTemplate *template.Template
+	OpArgs            k8s.OperationArgs
+
+	lock sync.Mutex
+	// claims maps remote namespaces to the namespace whose kubeconfig was written there.
+	claims map[string]string
+}
+
+// NewRemoteClusterSink returns a sink mirroring kubeconfig secrets to the configured remote clusters.
+func NewRemoteClusterSink(opArgs k8s.OperationArgs) (Sink, error) {
+	if len(opArgs.AppConfig().RemoteClusters.Values) == 0 {
+		return nil, fmt.Errorf("no remote clusters configured")
+	}
+	if opArgs.AppConfig().SourceClusterName == "" {
+		return nil, fmt.Errorf("the remote sink requires a source cluster name")
+	}
+	destinations := []*RemoteDestination{}
+	for _, remoteCluster := range opArgs.AppConfig().RemoteClusters.Values {
+		namespaceTemplate, err := template.New(remoteCluster.Name).Parse(remoteCluster.NamespaceTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace template of remote cluster '%s': %w", remoteCluster.Name, err)
+		}
+		if err := validateNamespaceTemplate(namespaceTemplate, opArgs); err != nil {
+			return nil, fmt.Errorf("invalid namespace template of remote cluster '%s': %w", remoteCluster.Name, err)
+		}
+		config, err := k8s.BuildRemoteKubernetesClientConfig(remoteCluster.KubeConfigPath, remoteCluster.Context)
+		if err != nil {
+			return nil, fmt.Errorf("failed building client configuration of remote cluster '%s': %w", remoteCluster.Name, err)
+		}
+		k8s.InstrumentClientConfig(config, remoteCluster.Name)
+		clientSet, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed building Kubernetes client of remote cluster '%s': %w", remoteCluster.Name, err)
+		}
+		destinations = append(destinations, &RemoteDestination{
+			Name:              remoteCluster.Name,
+			NamespaceTemplate: namespaceTemplate,
+			OpArgs: k8s.NewDefaultOperationArgs(opArgs.AppConfig(),
+				clientSet,
+				nil,
+				opArgs.Logger().Named("remote").With("destination", remoteCluster.Name)),
+		})
+	}
+	return NewRemoteClusterSinkWithDestinations(destinations, opArgs), nil
+}
+
+// NewRemoteClusterSinkWithDestinations returns a remote cluster sink
+// writing to already configured destinations.
+func NewRemoteClusterSinkWithDestinations(destinations []*RemoteDestination, opArgs k8s.OperationArgs) Sink {
+	return &remoteClusterSink{
+		destinations: destinations,
+		opArgs:       opArgs,
+	}
+}
+
+type remoteClusterSink struct {
+	destinations []*RemoteDestination
+	opArgs       k8s.OperationArgs
+}
+
+func (s *remoteClusterSink) Name() string {
+	return SinkRemote
+}
+
+// Store writes the kubeconfig secret to every destination.
+// A failing destination does not prevent writes to the other destinations.
+// The result is the most significant result of all destinations.
+func (s *remoteClusterSink) Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	// The destination operation arguments use the remote client set,
+	// the encryption recipients secret is read from the local cluster.
+	ctx, err := k8s.EnsureEncryptionRecipients(ctx, k8s.WithTraceLogger(ctx, s.opArgs))
+	if err != nil { // Logging taken care of.
+		return k8s.ResultFailed, err
+	}
+	failed := []string{}
+	results := []k8s.Result{}
+	for _, destination := range s.destinations {
+		destinationOpArgs := k8s.WithTraceLogger(ctx, destination.OpArgs)
+		remoteNamespace, err := destination.claim(targetNamespace)
+		if err == nil {
+			var result k8s.Result
+			result, err = k8s.CreateOrUpdateKubeConfigSecret(ctx, remoteNamespace, destinationOpArgs, kubeconfig, sourceSecret)
+			results = append(results, result)
+		} else {
+			destinationOpArgs.Logger().Error("Failed mapping remote namespace",
+				"namespace", targetNamespace,
+				"reason", err)
+		}
+		if err != nil { // Logging taken care of.
+			metrics.RecordRemoteSinkOperation(destination.Name, "store", false)
+			failed = append(failed, destination.Name)
+			continue
+		}
+		metrics.RecordRemoteSinkOperation(destination.Name, "store", true)
+	}
+	if len(failed) > 0 {
+		return k8s.ResultFailed, fmt.Errorf("failed storing kubeconfig in remote clusters: %s", strings.Join(failed, ", "))
+	}
+	return mostSignificantResult(results), nil
+}
+
+// mostSignificantResult returns the result telling the most about what changed.
+func mostSignificantResult(results []k8s.Result) k8s.Result {
+	significance := map[k8s.Result]int{
+		k8s.ResultUnchanged:  1,
+		k8s.ResultReportOnly: 2,
+		k8s.ResultSkipped:    2,
+		k8s.ResultUpdated:    3,
+		k8s.ResultCreated:    4,
+	}
+	mostSignificant := k8s.ResultUnchanged
+	for _, result := range results {
+		if significance[result] > significance[mostSignificant] {
+			mostSignificant = result
+		}
+	}
+	return mostSignificant
+}
+
+// Prune deletes kubeconfig secrets from remote namespaces which
+// no longer map to a processed namespace.
+func (s *remoteClusterSink) Prune(ctx context.Context, activeNamespaces []string) error {
+	failed := []string{}
+	for _, destination := range s.destinations {
+		if err := destination.prune(ctx, activeNamespaces); err != nil { // Logging taken care of.
+			metrics.RecordRemoteSinkOperation(destination.Name, "delete", false)
+			failed = append(failed, destination.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed pruning kubeconfigs in remote clusters: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// validateNamespaceTemplate rejects namespace templates mapping different namespaces
+// to the same remote namespace, like templates not using the namespace.
+func validateNamespaceTemplate(namespaceTemplate *template.Template, opArgs k8s.OperationArgs) error {
+	rendered := map[string]string{}
+	for _, ns := range []string{"tenant-a", "tenant-b"} {
+		buf := &bytes.Buffer{}
+		if err := namespaceTemplate.Execute(buf, &PathTemplateData{
+			Namespace:          ns,
+			ServiceAccountName: opArgs.AppConfig().ServiceAccountName,
+			SecretName:         opArgs.AppConfig().TenantSecretName(),
+		}); err != nil {
+			return err
+		}
+		if claimedBy, ok := rendered[buf.String()]; ok {
+			return fmt.Errorf("namespaces '%s' and '%s' both map to remote namespace '%s'", claimedBy, ns, buf.String())
+		}
+		rendered[buf.String()] = ns
+	}
+	return nil
+}
+
+func (d *RemoteDestination) namespace(targetNamespace string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := d.NamespaceTemplate.Execute(buf, &PathTemplateData{
+		Namespace:          targetNamespace,
+		ServiceAccountName: d.OpArgs.AppConfig().ServiceAccountName,
+		SecretName:         d.OpArgs.AppConfig().TenantSecretName(),
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// claim returns the remote namespace of the target namespace and fails
+// when the kubeconfig of another namespace is already written there.
+func (d *RemoteDestination) claim(targetNamespace string) (string, error) {
+	remoteNamespace, err := d.namespace(targetNamespace)
+	if err != nil {
+		return "", err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.claims == nil {
+		d.claims = map[string]string{}
+	}
+	if claimedBy, ok := d.claims[remoteNamespace]; ok && claimedBy != targetNamespace {
+		return "", fmt.Errorf("namespaces '%s' and '%s' both map to remote namespace '%s'", claimedBy, targetNamespace, remoteNamespace)
+	}
+	d.claims[remoteNamespace] = targetNamespace
+	return remoteNamespace, nil
+}
+
+func (d *RemoteDestination) prune(ctx context.Context, activeNamespaces []string) error {
+
+	expected := map[string]string{}
+	for _, ns := range activeNamespaces {
+		remoteNamespace, err := d.namespace(ns)
+		if err != nil {
+			d.OpArgs.Logger().Error("Failed rendering remote namespace",
+				"namespace", ns,
+				"reason", err)
+			return err
+		}
+		if claimedBy, ok := expected[remoteNamespace]; ok {
+			err := fmt.Errorf("namespaces '%s' and '%s' both map to remote namespace '%s'", claimedBy, ns, remoteNamespace)
+			d.OpArgs.Logger().Error("Not pruning a remote cluster with ambiguous namespaces",
+				"reason", err)
+			return err
+		}
+		expected[remoteNamespace] = ns
+	}
+
+	// Namespaces no longer processed release their remote namespaces.
+	d.lock.Lock()
+	d.claims = expected
+	d.lock.Unlock()
+
+	// Only secrets written from this cluster are pruned, other generators
+	// may mirror kubeconfigs of the same service account to the remote cluster.
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s",
+			configuration.OwnershipLabel, configuration.OwnershipLabelValue,
+			configuration.SourceClusterLabel, d.OpArgs.AppConfig().SourceClusterName),
+	}
+	if d.OpArgs.AppConfig().ImmutableSecrets {
+		listOptions.LabelSelector = fmt.Sprintf("%s,%s=%s", listOptions.LabelSelector,
+			configuration.ImmutableSecretGroupLabel, d.OpArgs.AppConfig().TenantSecretName())
+	} else {
+		listOptions.FieldSelector = fmt.Sprintf("metadata.name=%s", d.OpArgs.AppConfig().TenantSecretName())
+	}
+
+	secrets, err := d.OpArgs.ClientSet().CoreV1().Secrets(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		d.OpArgs.Logger().Error("Failed listing remote kubeconfig secrets",
+			"reason", err)
+		return err
+	}
+
+	stale := map[string]struct{}{}
+	for _, secret := range secrets.Items {
+		if _, ok := expected[secret.Namespace]; !ok {
+			stale[secret.Namespace] = struct{}{}
+		}
+	}
+
+	var lastErr error
+	for ns := range stale {
+		if err := k8s.DeleteKubeConfigSecret(ctx, ns, d.OpArgs); err != nil { // Logging taken care of.
+			lastErr = err
+			continue
+		}
+		metrics.RecordRemoteSinkOperation(d.Name, "delete", true)
+	}
+	return lastErr
+}
@@ -0,0 +1,202 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeClientSet returns a fake clientset handling server-side apply of secrets.
+// The fake clientset does not support apply patches, they are applied as merge patches.
+func newFakeClientSet(objects ...runtime.Object) *fake.Clientset {
+	clientSet := fake.NewSimpleClientset(objects...)
+	resource := corev1.SchemeGroupVersion.WithResource("secrets")
+	clientSet.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		existing, err := clientSet.Tracker().Get(resource, patchAction.GetNamespace(), patchAction.GetName())
+		if apiErrors.IsNotFound(err) {
+			secret := &corev1.Secret{}
+			if err := json.Unmarshal(patchAction.GetPatch(), secret); err != nil {
+				return true, nil, err
+			}
+			return true, secret, clientSet.Tracker().Create(resource, secret, patchAction.GetNamespace())
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		existingJSON, err := json.Marshal(existing)
+		if err != nil {
+			return true, nil, err
+		}
+		patchedJSON, err := jsonpatch.MergePatch(existingJSON, patchAction.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		secret := &corev1.Secret{}
+		if err := json.Unmarshal(patchedJSON, secret); err != nil {
+			return true, nil, err
+		}
+		return true, secret, clientSet.Tracker().Update(resource, secret, patchAction.GetNamespace())
+	})
+	return clientSet
+}
+
+func newTestRemoteAppConfig() *configuration.Config {
+	appConfig := newTestAppConfig()
+	appConfig.SourceClusterName = "source"
+	appConfig.FieldManager = "proxy-kubeconfig-generator"
+	appConfig.WriteRetrySteps = 1
+	return appConfig
+}
+
+func newTestRemoteDestination(name, namespaceTemplate string, clientSet *fake.Clientset, appConfig *configuration.Config) *RemoteDestination {
+	return &RemoteDestination{
+		Name:              name,
+		NamespaceTemplate: template.Must(template.New(name).Parse(namespaceTemplate)),
+		OpArgs:            k8s.NewDefaultOperationArgs(appConfig, clientSet, nil, hclog.NewNullLogger()),
+	}
+}
+
+func TestRemoteClusterSinkStore(t *testing.T) {
+	appConfig := newTestRemoteAppConfig()
+	clientSetA := newFakeClientSet()
+	clientSetB := newFakeClientSet()
+	sink := NewRemoteClusterSinkWithDestinations([]*RemoteDestination{
+		newTestRemoteDestination("a", "mirror-{{ .Namespace }}", clientSetA, appConfig),
+		newTestRemoteDestination("b", "{{ .Namespace }}", clientSetB, appConfig),
+	}, k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger()))
+	ctx := context.Background()
+
+	result, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultCreated {
+		t.Fatalf("expected created, got %s: %v", result, err)
+	}
+	for _, remote := range []struct {
+		clientSet *fake.Clientset
+		namespace string
+	}{{clientSetA, "mirror-team-a"}, {clientSetB, "team-a"}} {
+		secret, err := remote.clientSet.CoreV1().Secrets(remote.namespace).Get(ctx, appConfig.TenantSecretName(), metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected the secret in namespace '%s': %v", remote.namespace, err)
+		}
+		if secret.Labels[configuration.SourceClusterLabel] != "source" {
+			t.Fatalf("expected the source cluster label, got %v", secret.Labels)
+		}
+	}
+
+	result, err = sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultUnchanged {
+		t.Fatalf("expected unchanged, got %s: %v", result, err)
+	}
+}
+
+func TestRemoteClusterSinkStoreFailingDestination(t *testing.T) {
+	appConfig := newTestRemoteAppConfig()
+	failingClientSet := newFakeClientSet()
+	failingClientSet.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("remote cluster unreachable")
+	})
+	clientSet := newFakeClientSet()
+	sink := NewRemoteClusterSinkWithDestinations([]*RemoteDestination{
+		newTestRemoteDestination("failing", "{{ .Namespace }}", failingClientSet, appConfig),
+		newTestRemoteDestination("healthy", "{{ .Namespace }}", clientSet, appConfig),
+	}, k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger()))
+	ctx := context.Background()
+
+	result, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err == nil || result != k8s.ResultFailed {
+		t.Fatalf("expected a failure, got %s: %v", result, err)
+	}
+	if _, err := clientSet.CoreV1().Secrets("team-a").Get(ctx, appConfig.TenantSecretName(), metav1.GetOptions{}); err != nil {
+		t.Fatalf("expected the healthy destination to be written: %v", err)
+	}
+}
+
+func TestRemoteClusterSinkStoreCollidingNamespaces(t *testing.T) {
+	appConfig := newTestRemoteAppConfig()
+	clientSet := newFakeClientSet()
+	sink := NewRemoteClusterSinkWithDestinations([]*RemoteDestination{
+		newTestRemoteDestination("a", `{{ if eq .Namespace "team-b" }}team-a{{ else }}{{ .Namespace }}{{ end }}`, clientSet, appConfig),
+	}, k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger()))
+	ctx := context.Background()
+
+	if _, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := sink.Store(ctx, "team-b", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err == nil || result != k8s.ResultFailed {
+		t.Fatalf("expected the colliding namespace to fail, got %s: %v", result, err)
+	}
+}
+
+func TestRemoteClusterSinkPrune(t *testing.T) {
+	appConfig := newTestRemoteAppConfig()
+	remoteSecret := func(namespace, sourceCluster string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      appConfig.TenantSecretName(),
+				Namespace: namespace,
+				Labels: map[string]string{
+					configuration.OwnershipLabel:     configuration.OwnershipLabelValue,
+					configuration.SourceClusterLabel: sourceCluster,
+				},
+			},
+		}
+	}
+	clientSet := newFakeClientSet(
+		remoteSecret("team-a", "source"),
+		remoteSecret("team-b", "source"),
+		remoteSecret("team-c", "other"),
+	)
+	sink := NewRemoteClusterSinkWithDestinations([]*RemoteDestination{
+		newTestRemoteDestination("a", "{{ .Namespace }}", clientSet, appConfig),
+	}, k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger()))
+	ctx := context.Background()
+
+	if err := sink.(Pruner).Prune(ctx, []string{"team-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for namespace, expected := range map[string]bool{
+		"team-a": true,  // still processed
+		"team-b": false, // no longer processed
+		"team-c": true,  // written from another cluster
+	} {
+		_, err := clientSet.CoreV1().Secrets(namespace).Get(ctx, appConfig.TenantSecretName(), metav1.GetOptions{})
+		if exists := err == nil; exists != expected {
+			t.Fatalf("expected the secret in namespace '%s' to exist: %v, got: %v", namespace, expected, exists)
+		}
+	}
+}
+
+func TestValidateNamespaceTemplate(t *testing.T) {
+	opArgs := k8s.NewDefaultOperationArgs(newTestRemoteAppConfig(), nil, nil, hclog.NewNullLogger())
+	for namespaceTemplate, valid := range map[string]bool{
+		"{{ .Namespace }}":                 true,
+		"mirror-{{ .Namespace }}":          true,
+		"tenants":                          false,
+		"{{ .ServiceAccountName }}-mirror": false,
+	} {
+		err := validateNamespaceTemplate(template.Must(template.New("test").Parse(namespaceTemplate)), opArgs)
+		if (err == nil) != valid {
+			t.Fatalf("expected template '%s' to be valid: %v, got: %v", namespaceTemplate, valid, err)
+		}
+	}
+}
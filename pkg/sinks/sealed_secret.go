@@ -0,0 +1,354 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/sealedsecrets"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/yaml"
+)
+
+// NewSealedSecretSink returns a sink storing the kubeconfig as a Bitnami SealedSecret,
+// either in the cluster or as a YAML file.
+func NewSealedSecretSink(sealedSecretsConfig *configuration.SealedSecretsConfig, opArgs k8s.OperationArgs) (Sink, error) {
+	if err := sealedSecretsConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err := sealedsecrets.ValidateScope(sealedSecretsConfig.Scope); err != nil {
+		return nil, err
+	}
+	if opArgs.AppConfig().EncryptionEnabled() {
+		return nil, fmt.Errorf("the sealed-secret sink does not support encryption, the kubeconfig is already sealed")
+	}
+	sink := &sealedSecretSink{
+		sealedSecretsConfig: sealedSecretsConfig,
+		opArgs:              opArgs,
+	}
+	if sealedSecretsConfig.OutputPathTemplate != "" {
+		pathTemplate, err := template.New("sealed-secret-path").Parse(sealedSecretsConfig.OutputPathTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sealed secret output path template: %w", err)
+		}
+		sink.pathTemplate = pathTemplate
+	}
+	return sink, nil
+}
+
+type sealedSecretSink struct {
+	sealedSecretsConfig *configuration.SealedSecretsConfig
+	pathTemplate        *template.Template
+	opArgs              k8s.OperationArgs
+}
+
+func (s *sealedSecretSink) Name() string {
+	return SinkSealedSecret
+}
+
+func (s *sealedSecretSink) Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	logger := tracing.Logger(ctx, s.opArgs.Logger())
+
+	sourceResourceRevision := k8s.SourceResourceRevision(sourceSecret)
+
+	existing, err := s.load(ctx, targetNamespace)
+	if err != nil { // Logging taken care of.
+		return k8s.ResultFailed, err
+	}
+
+	publicKey, err := s.publicKey(ctx)
+	if err != nil { // Logging taken care of.
+		return k8s.ResultFailed, err
+	}
+
+	sealingSettings, err := sealedsecrets.Settings(s.sealedSecretsConfig.Scope, publicKey)
+	if err != nil {
+		logger.Error("Failed fingerprinting sealing certificate",
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	labels := map[string]string{
+		configuration.OwnershipLabel:                   configuration.OwnershipLabelValue,
+		s.opArgs.AppConfig().SourceSecretRevisionLabel: sourceResourceRevision,
+	}
+	if s.opArgs.AppConfig().SourceClusterName != "" {
+		labels[configuration.SourceClusterLabel] = s.opArgs.AppConfig().SourceClusterName
+	}
+
+	// adopting is set when the existing sealed secret was not written by the generator.
+	adopting := false
+	if existing != nil {
+		if existing.GetLabels()[configuration.OwnershipLabel] != configuration.OwnershipLabelValue {
+			if !s.opArgs.AppConfig().AdoptExisting {
+				err := fmt.Errorf("%w: sealed secret '%s' in namespace '%s' does not carry the '%s' label",
+					errors.ErrSecretNotOwned,
+					s.opArgs.AppConfig().TenantSecretName(),
+					targetNamespace,
+					configuration.OwnershipLabel)
+				metrics.RecordNotOwned(s.opArgs.AppConfig(), targetNamespace)
+				logger.Error("Refusing to modify a sealed secret not owned by the generator",
+					"namespace", targetNamespace,
+					"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+					"reason", err)
+				return k8s.ResultFailed, err
+			}
+			logger.Warn("Adopting existing sealed secret not owned by the generator",
+				"namespace", targetNamespace,
+				"secret-name", s.opArgs.AppConfig().TenantSecretName())
+			adopting = true
+		}
+		if s.opArgs.AppConfig().DisallowUpdates {
+			logger.Info("Sealed secret exists and updates are disabled",
+				"namespace", targetNamespace,
+				"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+				"source-secret-resource-version", sourceResourceRevision)
+			return k8s.ResultSkipped, nil
+		}
+		if !adopting &&
+			existing.GetLabels()[s.opArgs.AppConfig().SourceSecretRevisionLabel] == sourceResourceRevision &&
+			existing.GetLabels()[configuration.SourceClusterLabel] == s.opArgs.AppConfig().SourceClusterName &&
+			existing.GetAnnotations()[configuration.SealingSettingsAnnotation] == sealingSettings {
+			logger.Info("Nothing to do, sealed secret already exists and was sealed from current source secret resource version with current sealing settings",
+				"namespace", targetNamespace,
+				"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+				"source-secret-resource-version", sourceResourceRevision,
+				"sealing-settings", sealingSettings)
+			return k8s.ResultUnchanged, nil
+		}
+	}
+
+	configBuffer, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		logger.Error("Failed serializing kubeconfig to buffer",
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	secretData, err := k8s.KubeConfigSecretData(ctx, configBuffer, s.opArgs)
+	if err != nil { // Logging taken care of.
+		return k8s.ResultFailed, err
+	}
+
+	if s.opArgs.AppConfig().ReportOnly {
+		logger.Info("Report only: would write a sealed secret",
+			"namespace", targetNamespace,
+			"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+			"scope", s.sealedSecretsConfig.Scope,
+			"sealed-secret-exists", existing != nil,
+			"source-secret-resource-version", sourceResourceRevision)
+		return k8s.ResultReportOnly, nil
+	}
+
+	sealedSecret, err := sealedsecrets.New(publicKey,
+		s.sealedSecretsConfig.Scope,
+		targetNamespace,
+		s.opArgs.AppConfig().TenantSecretName(),
+		labels,
+		map[string]string{
+			configuration.SealingSettingsAnnotation: sealingSettings,
+		},
+		secretData)
+	if err != nil {
+		logger.Error("Failed sealing kubeconfig secret",
+			"namespace", targetNamespace,
+			"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	result := k8s.ResultCreated
+	if existing != nil {
+		result = k8s.ResultUpdated
+	}
+
+	if s.pathTemplate != nil {
+		if err := s.writeFile(targetNamespace, sealedSecret); err != nil { // Logging taken care of.
+			return k8s.ResultFailed, err
+		}
+		return result, nil
+	}
+
+	patch, err := sealedSecret.MarshalJSON()
+	if err != nil {
+		logger.Error("Failed serializing sealed secret",
+			"namespace", targetNamespace,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	// An adopted sealed secret belongs to another field manager, the apply takes it over.
+	force := s.opArgs.AppConfig().ForceApply || adopting
+	_, err = s.opArgs.DynamicClient().Resource(sealedsecrets.Resource).Namespace(targetNamespace).Patch(ctx,
+		sealedSecret.GetName(),
+		types.ApplyPatchType,
+		patch,
+		metav1.PatchOptions{
+			FieldManager: s.opArgs.AppConfig().FieldManager,
+			Force:        &force,
+		})
+	if err != nil {
+		logger.Error("Failed applying sealed secret",
+			"namespace", targetNamespace,
+			"secret-name", sealedSecret.GetName(),
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	logger.Info("Sealed secret applied",
+		"namespace", targetNamespace,
+		"secret-name", sealedSecret.GetName(),
+		"scope", s.sealedSecretsConfig.Scope,
+		"source-secret-resource-version", sourceResourceRevision)
+
+	return result, nil
+}
+
+// load returns the existing sealed secret, nil if it does not exist.
+func (s *sealedSecretSink) load(ctx context.Context, targetNamespace string) (*unstructured.Unstructured, error) {
+
+	if s.pathTemplate != nil {
+		path, err := s.path(targetNamespace)
+		if err != nil {
+			s.opArgs.Logger().Error("Failed rendering sealed secret path",
+				"namespace", targetNamespace,
+				"reason", err)
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			s.opArgs.Logger().Error("Failed reading existing sealed secret file",
+				"namespace", targetNamespace,
+				"path", path,
+				"reason", err)
+			return nil, err
+		}
+		existing := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &existing.Object); err != nil {
+			s.opArgs.Logger().Error("Failed parsing existing sealed secret file",
+				"namespace", targetNamespace,
+				"path", path,
+				"reason", err)
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	existing, err := s.opArgs.DynamicClient().Resource(sealedsecrets.Resource).Namespace(targetNamespace).Get(ctx,
+		s.opArgs.AppConfig().TenantSecretName(),
+		metav1.GetOptions{})
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			return nil, nil
+		}
+		s.opArgs.Logger().Error("Failed checking if sealed secret exists",
+			"namespace", targetNamespace,
+			"secret-name", s.opArgs.AppConfig().TenantSecretName(),
+			"reason", err)
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *sealedSecretSink) writeFile(targetNamespace string, sealedSecret *unstructured.Unstructured) error {
+	path, err := s.path(targetNamespace)
+	if err != nil {
+		s.opArgs.Logger().Error("Failed rendering sealed secret path",
+			"namespace", targetNamespace,
+			"reason", err)
+		return err
+	}
+	data, err := yaml.Marshal(sealedSecret.Object)
+	if err != nil {
+		s.opArgs.Logger().Error("Failed serializing sealed secret",
+			"namespace", targetNamespace,
+			"reason", err)
+		return err
+	}
+	if err := writeFileAtomic(path, data, 0o644); err != nil {
+		s.opArgs.Logger().Error("Failed writing sealed secret file",
+			"namespace", targetNamespace,
+			"path", path,
+			"reason", err)
+		return err
+	}
+	s.opArgs.Logger().Info("Sealed secret file written",
+		"namespace", targetNamespace,
+		"path", path,
+		"scope", s.sealedSecretsConfig.Scope)
+	return nil
+}
+
+// publicKey loads the sealing certificate. The certificate is loaded on every store
+// so that a rotated sealing key is picked up.
+func (s *sealedSecretSink) publicKey(ctx context.Context) (*rsa.PublicKey, error) {
+	var data []byte
+	if s.sealedSecretsConfig.CertFile != "" {
+		fileData, err := os.ReadFile(s.sealedSecretsConfig.CertFile)
+		if err != nil {
+			s.opArgs.Logger().Error("Failed reading sealing certificate file",
+				"path", s.sealedSecretsConfig.CertFile,
+				"reason", err)
+			return nil, err
+		}
+		data = fileData
+	} else {
+		secret, err := s.opArgs.ClientSet().CoreV1().Secrets(s.sealedSecretsConfig.CertSecretNamespace).Get(ctx,
+			s.sealedSecretsConfig.CertSecretName,
+			metav1.GetOptions{})
+		if err != nil {
+			s.opArgs.Logger().Error("Failed fetching sealing certificate secret",
+				"namespace", s.sealedSecretsConfig.CertSecretNamespace,
+				"secret-name", s.sealedSecretsConfig.CertSecretName,
+				"reason", err)
+			return nil, err
+		}
+		secretData, ok := secret.Data[s.sealedSecretsConfig.CertSecretKey]
+		if !ok {
+			err := fmt.Errorf("no '%s' key in sealing certificate secret '%s'", s.sealedSecretsConfig.CertSecretKey, secret.Name)
+			s.opArgs.Logger().Error("Required key not found in sealing certificate secret",
+				"namespace", s.sealedSecretsConfig.CertSecretNamespace,
+				"secret-name", s.sealedSecretsConfig.CertSecretName,
+				"reason", err)
+			return nil, err
+		}
+		data = secretData
+	}
+	publicKey, err := sealedsecrets.ParsePublicKey(data)
+	if err != nil {
+		s.opArgs.Logger().Error("Failed parsing sealing certificate",
+			"reason", err)
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+func (s *sealedSecretSink) path(targetNamespace string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := s.pathTemplate.Execute(buf, &PathTemplateData{
+		Namespace:          targetNamespace,
+		ServiceAccountName: s.opArgs.AppConfig().ServiceAccountName,
+		SecretName:         s.opArgs.AppConfig().TenantSecretName(),
+	}); err != nil {
+		return "", err
+	}
+	return filepath.Clean(buf.String()), nil
+}
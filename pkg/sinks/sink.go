@@ -0,0 +1,99 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+
+	corev1 "k8s.io/api/core/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// SinkKubernetes is the name of the Kubernetes secret sink.
+	SinkKubernetes = "kubernetes"
+	// SinkVault is the name of the HashiCorp Vault KV v2 sink.
+	SinkVault = "vault"
+	// SinkRemote is the name of the remote cluster sink.
+	SinkRemote = "remote"
+	// SinkFile is the name of the file system sink.
+	SinkFile = "file"
+	// SinkSealedSecret is the name of the Bitnami SealedSecret sink.
+	SinkSealedSecret = "sealed-secret"
+)
+
+// Sink stores a generated kubeconfig for a target namespace.
+type Sink interface {
+	// Name returns the name of the sink.
+	Name() string
+	// Store stores the kubeconfig generated for the target namespace and returns what was done.
+	// Implementations must honour the disallow updates and report only settings
+	// and skip writes when the stored kubeconfig was generated from the same
+	// source secret revision.
+	Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error)
+}
+
+// Pruner is implemented by sinks which remove kubeconfigs of namespaces no longer processed.
+type Pruner interface {
+	// Prune removes kubeconfigs stored for namespaces other than the active ones.
+	Prune(ctx context.Context, activeNamespaces []string) error
+}
+
+// Names returns the names of the sinks selected in the configuration,
+// the Kubernetes secret sink when no sinks are selected.
+func Names(appConfig *configuration.Config) []string {
+	if len(appConfig.Sinks.Values) == 0 {
+		return []string{SinkKubernetes}
+	}
+	return appConfig.Sinks.Values
+}
+
+// NewSinks returns the sinks selected in the configuration.
+// The Kubernetes secret sink is used when no sinks are selected.
+func NewSinks(vaultConfig *configuration.VaultConfig, fileConfig *configuration.FileSinkConfig, sealedSecretsConfig *configuration.SealedSecretsConfig, opArgs k8s.OperationArgs) ([]Sink, error) {
+	names := Names(opArgs.AppConfig())
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	// Both sinks write a secret named like the kubeconfig secret to the same namespace.
+	if selected[SinkKubernetes] && selected[SinkSealedSecret] && sealedSecretsConfig.OutputPathTemplate == "" {
+		return nil, fmt.Errorf("the kubernetes and sealed-secret sinks can't be combined unless sealed secrets are written to files, both write the '%s' secret", opArgs.AppConfig().TenantSecretName())
+	}
+	sinks := []Sink{}
+	for _, name := range names {
+		switch name {
+		case SinkKubernetes:
+			sinks = append(sinks, NewKubernetesSecretSink(opArgs))
+		case SinkVault:
+			sink, err := NewVaultSink(vaultConfig, opArgs)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case SinkRemote:
+			sink, err := NewRemoteClusterSink(opArgs)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case SinkFile:
+			sink, err := NewFileSink(fileConfig, opArgs)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case SinkSealedSecret:
+			sink, err := NewSealedSecretSink(sealedSecretsConfig, opArgs)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown sink '%s'", name)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,369 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/metrics"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	vaultDataKeySourceRevision = "source_revision"
+	vaultDataKeyManagedBy      = "managed_by"
+)
+
+// PathTemplateData is the data available to the sink path templates.
+type PathTemplateData struct {
+	Namespace          string
+	ServiceAccountName string
+	SecretName         string
+}
+
+// NewVaultSink returns a sink storing the kubeconfig in a HashiCorp Vault KV v2 secrets engine.
+// The sink logs in to Vault using the Kubernetes auth method.
+func NewVaultSink(vaultConfig *configuration.VaultConfig, opArgs k8s.OperationArgs) (Sink, error) {
+	if err := vaultConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if opArgs.AppConfig().EncryptionEnabled() {
+		return nil, fmt.Errorf("the vault sink does not support encryption")
+	}
+
+	pathTemplate, err := template.New("vault-path").Parse(vaultConfig.PathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault path template: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if vaultConfig.CACertFile != "" {
+		pem, err := os.ReadFile(vaultConfig.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading vault CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in vault CA certificate file '%s'", vaultConfig.CACertFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return NewVaultSinkWithClient(vaultConfig, pathTemplate, &http.Client{
+		Transport: transport,
+		Timeout:   vaultConfig.Timeout,
+	}, opArgs), nil
+}
+
+// NewVaultSinkWithClient returns a Vault sink using the provided HTTP client.
+func NewVaultSinkWithClient(vaultConfig *configuration.VaultConfig, pathTemplate *template.Template, httpClient *http.Client, opArgs k8s.OperationArgs) Sink {
+	return &vaultSink{
+		vaultConfig:  vaultConfig,
+		pathTemplate: pathTemplate,
+		httpClient:   httpClient,
+		opArgs:       opArgs,
+	}
+}
+
+type vaultSink struct {
+	vaultConfig  *configuration.VaultConfig
+	pathTemplate *template.Template
+	httpClient   *http.Client
+	opArgs       k8s.OperationArgs
+
+	lock        sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+type vaultKVReadResponse struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+func (s *vaultSink) Name() string {
+	return SinkVault
+}
+
+func (s *vaultSink) Store(ctx context.Context, targetNamespace string, kubeconfig *clientcmdapi.Config, sourceSecret *corev1.Secret) (k8s.Result, error) {
+	logger := tracing.Logger(ctx, s.opArgs.Logger())
+
+	path, err := s.path(targetNamespace)
+	if err != nil {
+		logger.Error("Failed rendering vault path",
+			"namespace", targetNamespace,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	existing, found, err := s.read(ctx, path)
+	if err != nil {
+		logger.Error("Failed reading vault secret",
+			"namespace", targetNamespace,
+			"vault-path", path,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	sourceResourceRevision := k8s.SourceResourceRevision(sourceSecret)
+	// Deleted and destroyed secrets keep their version, the write has to check against it.
+	version := existing.Data.Metadata.Version
+
+	if found {
+
+		if existing.Data.Data[vaultDataKeyManagedBy] != configuration.OwnershipLabelValue && !s.opArgs.AppConfig().AdoptExisting {
+			err := fmt.Errorf("%w: vault secret '%s' does not carry the '%s' field",
+				errors.ErrSecretNotOwned,
+				path,
+				vaultDataKeyManagedBy)
+			metrics.RecordNotOwned(s.opArgs.AppConfig(), targetNamespace)
+			logger.Error("Refusing to modify a vault secret not owned by the generator",
+				"namespace", targetNamespace,
+				"vault-path", path,
+				"reason", err)
+			return k8s.ResultFailed, err
+		}
+
+		if s.opArgs.AppConfig().DisallowUpdates {
+			logger.Info("Vault secret exists and updates are disabled",
+				"namespace", targetNamespace,
+				"vault-path", path,
+				"existing-secret-version", version,
+				"source-secret-resource-version", sourceResourceRevision)
+			return k8s.ResultSkipped, nil
+		}
+
+		if existing.Data.Data[vaultDataKeySourceRevision] == sourceResourceRevision {
+			logger.Info("Nothing to do, vault secret already exists and was generated using current source secret resource version",
+				"namespace", targetNamespace,
+				"vault-path", path,
+				"source-secret-resource-version", sourceResourceRevision)
+			return k8s.ResultUnchanged, nil
+		}
+	}
+
+	configBuffer, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		logger.Error("Failed serializing kubeconfig to buffer",
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	if s.opArgs.AppConfig().ReportOnly {
+		logger.Info("Report only: would write a vault secret",
+			"namespace", targetNamespace,
+			"vault-path", path,
+			"existing-secret-version", version,
+			"source-secret-resource-version", sourceResourceRevision,
+			"secret-data-size", len(configBuffer))
+		return k8s.ResultReportOnly, nil
+	}
+
+	data := map[string]string{
+		s.opArgs.AppConfig().KubeConfigSecretKey: string(configBuffer),
+		vaultDataKeySourceRevision:               sourceResourceRevision,
+		vaultDataKeyManagedBy:                    configuration.OwnershipLabelValue,
+	}
+
+	if err := s.write(ctx, path, data, version); err != nil {
+		logger.Error("Failed writing vault secret",
+			"namespace", targetNamespace,
+			"vault-path", path,
+			"existing-secret-version", version,
+			"reason", err)
+		return k8s.ResultFailed, err
+	}
+
+	result := k8s.ResultCreated
+	if found {
+		result = k8s.ResultUpdated
+	}
+
+	logger.Info("Vault secret written",
+		"namespace", targetNamespace,
+		"vault-path", path,
+		"previous-secret-version", version,
+		"source-secret-resource-version", sourceResourceRevision)
+
+	return result, nil
+}
+
+func (s *vaultSink) path(targetNamespace string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := s.pathTemplate.Execute(buf, &PathTemplateData{
+		Namespace:          targetNamespace,
+		ServiceAccountName: s.opArgs.AppConfig().ServiceAccountName,
+		SecretName:         s.opArgs.AppConfig().TenantSecretName(),
+	}); err != nil {
+		return "", err
+	}
+	return strings.Trim(buf.String(), "/"), nil
+}
+
+func (s *vaultSink) dataURL(path string) string {
+	return fmt.Sprintf("%s/v1/%s/data/%s",
+		strings.TrimRight(s.vaultConfig.Address, "/"),
+		strings.Trim(s.vaultConfig.Mount, "/"),
+		path)
+}
+
+// read reads the secret at the path. Vault responds with not found to reads of
+// deleted and destroyed secrets too, the response then holds the metadata of the secret.
+func (s *vaultSink) read(ctx context.Context, path string) (*vaultKVReadResponse, bool, error) {
+	response := &vaultKVReadResponse{}
+	status, err := s.do(ctx, http.MethodGet, s.dataURL(path), nil, response)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return response, false, nil
+		}
+		return nil, false, err
+	}
+	return response, true, nil
+}
+
+func (s *vaultSink) write(ctx context.Context, path string, data map[string]string, version int) error {
+	_, err := s.do(ctx, http.MethodPost, s.dataURL(path), map[string]interface{}{
+		"options": map[string]interface{}{
+			"cas": version,
+		},
+		"data": data,
+	}, nil)
+	return err
+}
+
+// do executes an authenticated Vault request, logging in again once
+// if Vault rejects the cached token.
+func (s *vaultSink) do(ctx context.Context, method, url string, body, target interface{}) (int, error) {
+	token, err := s.clientToken(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+	status, err := s.request(ctx, method, url, token, body, target)
+	if status == http.StatusForbidden {
+		if token, err = s.clientToken(ctx, true); err != nil {
+			return 0, err
+		}
+		status, err = s.request(ctx, method, url, token, body, target)
+	}
+	return status, err
+}
+
+func (s *vaultSink) clientToken(ctx context.Context, forceLogin bool) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !forceLogin && s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	jwt, err := os.ReadFile(s.vaultConfig.JWTPath)
+	if err != nil {
+		return "", fmt.Errorf("failed reading vault login token: %w", err)
+	}
+
+	response := &vaultLoginResponse{}
+	if _, err := s.request(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v1/auth/%s/login",
+			strings.TrimRight(s.vaultConfig.Address, "/"),
+			strings.Trim(s.vaultConfig.AuthMount, "/")),
+		"",
+		map[string]string{
+			"role": s.vaultConfig.AuthRole,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}, response); err != nil {
+		return "", fmt.Errorf("vault login failed: %w", err)
+	}
+
+	if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login failed: no client token in response")
+	}
+
+	s.token = response.Auth.ClientToken
+	// Renew the token a little before it expires.
+	s.tokenExpiry = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 9 / 10)
+
+	s.opArgs.Logger().Debug("Logged in to vault",
+		"vault-address", s.vaultConfig.Address,
+		"vault-auth-role", s.vaultConfig.AuthRole,
+		"token-lease-duration", response.Auth.LeaseDuration)
+
+	return s.token, nil
+}
+
+func (s *vaultSink) request(ctx context.Context, method, url, token string, body, target interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, err
+	}
+
+	if response.StatusCode == http.StatusNotFound && target != nil && len(responseBody) > 0 {
+		// Not found responses of deleted secrets carry the secret metadata.
+		_ = json.Unmarshal(responseBody, target)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("vault responded with status %d to %s %s: %s",
+			response.StatusCode, method, url, strings.TrimSpace(string(responseBody)))
+	}
+
+	if target != nil && len(responseBody) > 0 {
+		if err := json.Unmarshal(responseBody, target); err != nil {
+			return response.StatusCode, err
+		}
+	}
+
+	return response.StatusCode, nil
+}
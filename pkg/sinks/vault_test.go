@@ -0,0 +1,252 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/configuration"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/errors"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// fakeVault is a KV v2 secrets engine with the Kubernetes auth method.
+type fakeVault struct {
+	lock     sync.Mutex
+	tokens   map[string]bool
+	logins   int
+	secrets  map[string]map[string]string
+	versions map[string]int
+	writes   int
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{
+		tokens:   map[string]bool{},
+		secrets:  map[string]map[string]string{},
+		versions: map[string]int{},
+	}
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if r.URL.Path == "/v1/auth/kubernetes/login" {
+		v.logins = v.logins + 1
+		token := "token-" + strings.Repeat("x", v.logins)
+		v.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   token,
+				"lease_duration": 3600,
+			},
+		})
+		return
+	}
+
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := v.secrets[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if version, deleted := v.versions[path]; deleted {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{
+						"data":     nil,
+						"metadata": map[string]interface{}{"version": version},
+					},
+				})
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": v.versions[path]},
+			},
+		})
+	case http.MethodPost:
+		request := struct {
+			Options struct {
+				CAS int `json:"cas"`
+			} `json:"options"`
+			Data map[string]string `json:"data"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request.Options.CAS != v.versions[path] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+		v.writes = v.writes + 1
+		v.versions[path] = v.versions[path] + 1
+		v.secrets[path] = request.Data
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"version": v.versions[path]},
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestVaultSink(t *testing.T, vault *fakeVault, appConfig *configuration.Config) Sink {
+	t.Helper()
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	vaultConfig := &configuration.VaultConfig{
+		Address:      server.URL,
+		Mount:        "secret",
+		PathTemplate: "tenants/{{ .Namespace }}/{{ .SecretName }}",
+		AuthMount:    "kubernetes",
+		AuthRole:     "generator",
+		JWTPath:      jwtPath,
+	}
+	pathTemplate := template.Must(template.New("vault-path").Parse(vaultConfig.PathTemplate))
+	opArgs := k8s.NewDefaultOperationArgs(appConfig, nil, nil, hclog.NewNullLogger())
+	return NewVaultSinkWithClient(vaultConfig, pathTemplate, server.Client(), opArgs)
+}
+
+func newTestAppConfig() *configuration.Config {
+	return &configuration.Config{
+		ServiceAccountName:        "tenant",
+		KubeConfigSecretKey:       "kubeconfig",
+		SourceSecretRevisionLabel: "proxy-kubeconfig-generator/source-revision",
+		Server:                    "https://proxy.example.com",
+	}
+}
+
+func newTestSourceSecret(resourceVersion string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "proxy-tls",
+			Namespace:       "proxy",
+			ResourceVersion: resourceVersion,
+		},
+	}
+}
+
+func newTestKubeConfig() *clientcmdapi.Config {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["default-cluster"] = &clientcmdapi.Cluster{Server: "https://proxy.example.com"}
+	return kubeconfig
+}
+
+func TestVaultSinkStore(t *testing.T) {
+	vault := newFakeVault()
+	sink := newTestVaultSink(t, vault, newTestAppConfig())
+	ctx := context.Background()
+
+	result, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultCreated {
+		t.Fatalf("expected created, got %s: %v", result, err)
+	}
+	data := vault.secrets["tenants/team-a/tenant-kubeconfig"]
+	if data[vaultDataKeyManagedBy] != configuration.OwnershipLabelValue {
+		t.Fatalf("expected the ownership field, got %v", data)
+	}
+	if !strings.Contains(data["kubeconfig"], "https://proxy.example.com") {
+		t.Fatalf("expected the kubeconfig, got %v", data)
+	}
+
+	result, err = sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultUnchanged {
+		t.Fatalf("expected unchanged, got %s: %v", result, err)
+	}
+
+	result, err = sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("2"))
+	if err != nil || result != k8s.ResultUpdated {
+		t.Fatalf("expected updated, got %s: %v", result, err)
+	}
+	if vault.versions["tenants/team-a/tenant-kubeconfig"] != 2 {
+		t.Fatalf("expected version 2, got %d", vault.versions["tenants/team-a/tenant-kubeconfig"])
+	}
+	if vault.logins != 1 {
+		t.Fatalf("expected the token to be reused, got %d logins", vault.logins)
+	}
+}
+
+func TestVaultSinkStoreDeletedSecret(t *testing.T) {
+	vault := newFakeVault()
+	// The latest version was deleted, Vault keeps the version number.
+	vault.versions["tenants/team-a/tenant-kubeconfig"] = 3
+	sink := newTestVaultSink(t, vault, newTestAppConfig())
+
+	result, err := sink.Store(context.Background(), "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultCreated {
+		t.Fatalf("expected created, got %s: %v", result, err)
+	}
+	if vault.versions["tenants/team-a/tenant-kubeconfig"] != 4 {
+		t.Fatalf("expected version 4, got %d", vault.versions["tenants/team-a/tenant-kubeconfig"])
+	}
+}
+
+func TestVaultSinkStoreNotOwned(t *testing.T) {
+	vault := newFakeVault()
+	vault.secrets["tenants/team-a/tenant-kubeconfig"] = map[string]string{"kubeconfig": "written by hand"}
+	vault.versions["tenants/team-a/tenant-kubeconfig"] = 1
+	sink := newTestVaultSink(t, vault, newTestAppConfig())
+
+	result, err := sink.Store(context.Background(), "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if !stdErrors.Is(err, errors.ErrSecretNotOwned) || result != k8s.ResultFailed {
+		t.Fatalf("expected a not owned failure, got %s: %v", result, err)
+	}
+	if vault.writes != 0 {
+		t.Fatalf("expected no writes, got %d", vault.writes)
+	}
+
+	appConfig := newTestAppConfig()
+	appConfig.AdoptExisting = true
+	sink = newTestVaultSink(t, vault, appConfig)
+	result, err = sink.Store(context.Background(), "team-a", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultUpdated {
+		t.Fatalf("expected the secret to be adopted, got %s: %v", result, err)
+	}
+}
+
+func TestVaultSinkStoreLogsInAgain(t *testing.T) {
+	vault := newFakeVault()
+	sink := newTestVaultSink(t, vault, newTestAppConfig())
+	ctx := context.Background()
+
+	if _, err := sink.Store(ctx, "team-a", newTestKubeConfig(), newTestSourceSecret("1")); err != nil {
+		t.Fatal(err)
+	}
+	// Revoke the cached token.
+	vault.tokens = map[string]bool{}
+
+	result, err := sink.Store(ctx, "team-b", newTestKubeConfig(), newTestSourceSecret("1"))
+	if err != nil || result != k8s.ResultCreated {
+		t.Fatalf("expected created, got %s: %v", result, err)
+	}
+	if vault.logins != 2 {
+		t.Fatalf("expected a second login, got %d logins", vault.logins)
+	}
+}
@@ -0,0 +1,217 @@
+package status
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/k8s"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Entry is the generation state of a kubeconfig stored by a job in a namespace.
+type Entry struct {
+	Job              string     `json:"job"`
+	Namespace        string     `json:"namespace"`
+	LastAttempt      time.Time  `json:"lastAttempt"`
+	LastSuccess      *time.Time `json:"lastSuccess,omitempty"`
+	LastError        string     `json:"lastError,omitempty"`
+	SourceRevision   string     `json:"sourceRevision,omitempty"`
+	CredentialExpiry *time.Time `json:"credentialExpiry,omitempty"`
+	Action           k8s.Result `json:"action"`
+}
+
+// Store holds the generation state of every job and namespace in memory.
+type Store struct {
+	sync.RWMutex
+	entries map[string]*Entry
+}
+
+// NewStore returns an empty state store.
+func NewStore() *Store {
+	return &Store{
+		entries: map[string]*Entry{},
+	}
+}
+
+// RecordSuccess records a successful attempt.
+func (s *Store) RecordSuccess(job, namespace, sourceRevision string, kubeconfig *clientcmdapi.Config, result k8s.Result) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now().UTC()
+	entry := s.entry(job, namespace)
+	entry.LastAttempt = now
+	entry.LastSuccess = &now
+	entry.LastError = ""
+	entry.SourceRevision = sourceRevision
+	entry.CredentialExpiry = CredentialExpiry(kubeconfig)
+	entry.Action = result
+}
+
+// RecordFailure records a failed attempt, the state of the last success is kept.
+func (s *Store) RecordFailure(job, namespace string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	entry := s.entry(job, namespace)
+	entry.LastAttempt = time.Now().UTC()
+	entry.LastError = err.Error()
+	entry.Action = k8s.ResultFailed
+}
+
+// Retain removes the entries of namespaces other than the active ones.
+func (s *Store) Retain(activeNamespaces []string) {
+	active := map[string]struct{}{}
+	for _, ns := range activeNamespaces {
+		active[ns] = struct{}{}
+	}
+	s.Lock()
+	defer s.Unlock()
+	for key, entry := range s.entries {
+		if _, ok := active[entry.Namespace]; !ok {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Entries returns a copy of all entries sorted by namespace and job.
+func (s *Store) Entries() []Entry {
+	s.RLock()
+	defer s.RUnlock()
+	entries := []Entry{}
+	for _, entry := range s.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace == entries[j].Namespace {
+			return entries[i].Job < entries[j].Job
+		}
+		return entries[i].Namespace < entries[j].Namespace
+	})
+	return entries
+}
+
+func (s *Store) entry(job, namespace string) *Entry {
+	key := job + "/" + namespace
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &Entry{Job: job, Namespace: namespace}
+		s.entries[key] = entry
+	}
+	return entry
+}
+
+// Handler serves the entries as JSON, or as an HTML table to browsers
+// and when the format query parameter is html.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries := s.Entries()
+		format := r.URL.Query().Get("format")
+		if format == "html" || (format == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			statusTemplate.Execute(w, entries)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(entries)
+	})
+}
+
+// CredentialExpiry returns the expiry of the credential in the current context of the kubeconfig,
+// the expiry of the token when it is a JWT or the expiry of the client certificate.
+// Returns nil when the credential does not expire or the expiry is not known.
+func CredentialExpiry(kubeconfig *clientcmdapi.Config) *time.Time {
+	if kubeconfig == nil {
+		return nil
+	}
+	kubeContext, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return nil
+	}
+	authInfo, ok := kubeconfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return nil
+	}
+	if authInfo.Token != "" {
+		return tokenExpiry(authInfo.Token)
+	}
+	if len(authInfo.ClientCertificateData) > 0 {
+		return certificateExpiry(authInfo.ClientCertificateData)
+	}
+	return nil
+}
+
+func tokenExpiry(token string) *time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return nil
+	}
+	expiry := time.Unix(claims.Exp, 0).UTC()
+	return &expiry
+}
+
+func certificateExpiry(certificateData []byte) *time.Time {
+	block, _ := pem.Decode(certificateData)
+	if block == nil {
+		return nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	expiry := certificate.NotAfter.UTC()
+	return &expiry
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": func(value interface{}) string {
+		switch t := value.(type) {
+		case time.Time:
+			return t.Format(time.RFC3339)
+		case *time.Time:
+			if t != nil {
+				return t.Format(time.RFC3339)
+			}
+		}
+		return "-"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>proxy-kubeconfig-generator status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>proxy-kubeconfig-generator status</h1>
+<table>
+<tr><th>Namespace</th><th>Job</th><th>Action</th><th>Last attempt</th><th>Last success</th><th>Source revision</th><th>Credential expiry</th><th>Last error</th></tr>
+{{- range . }}
+<tr{{ if .LastError }} class="failed"{{ end }}><td>{{ .Namespace }}</td><td>{{ .Job }}</td><td>{{ .Action }}</td><td>{{ time .LastAttempt }}</td><td>{{ time .LastSuccess }}</td><td>{{ .SourceRevision }}</td><td>{{ time .CredentialExpiry }}</td><td>{{ .LastError }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/radekg/proxy-kubeconfig-generator/pkg/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultServiceName is the service name of the spans unless OTEL_SERVICE_NAME is set.
+	DefaultServiceName = "proxy-kubeconfig-generator"
+
+	tracerName = "github.com/radekg/proxy-kubeconfig-generator"
+)
+
+// Enabled returns true when spans are exported. Export is configured with the standard
+// OpenTelemetry environment variables and enabled when an OTLP endpoint is set or
+// OTEL_TRACES_EXPORTER is otlp.
+func Enabled() bool {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return false
+	}
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "otlp":
+		return true
+	case "":
+		return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+			os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	default:
+		return false
+	}
+}
+
+// Setup installs the global tracer provider exporting spans with OTLP over gRPC or HTTP,
+// as selected by OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL.
+// The returned function flushes the pending spans and stops the export.
+func Setup(ctx context.Context, logger hclog.Logger) (func(context.Context) error, error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol == "" {
+		protocol = "http/protobuf"
+	}
+
+	var exporter *otlptrace.Exporter
+	var err error
+	switch protocol {
+	case "grpc":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol '%s', expected grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed creating OTLP trace exporter: %w", err)
+	}
+
+	// Attributes from OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", DefaultServiceName),
+			attribute.String("service.version", version.Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, fmt.Errorf("failed creating trace resource: %w", err)
+	}
+
+	// The sampler is configured with OTEL_TRACES_SAMPLER by the SDK.
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("Tracing failed", "reason", err)
+	}))
+
+	logger.Info("Exporting traces",
+		"protocol", protocol,
+		"resource", res.Set().Encoded(attribute.DefaultEncoder()))
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the generator.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Logger returns the logger with the trace ID of the span in the context,
+// the logger itself when the context has no span.
+func Logger(ctx context.Context, logger hclog.Logger) hclog.Logger {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return logger
+	}
+	return logger.With("trace-id", spanContext.TraceID().String())
+}
+
+// RecordError marks the span failed with the error, when the error is not nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,19 @@
+package version
+
+import "runtime"
+
+// Version and Commit are set at build time:
+//
+//	go build -ldflags "-X github.com/radekg/proxy-kubeconfig-generator/pkg/version.Version=v1.2.3 \
+//	  -X github.com/radekg/proxy-kubeconfig-generator/pkg/version.Commit=$(git rev-parse HEAD)"
+var (
+	// Version is the released version of the generator.
+	Version = "dev"
+	// Commit is the commit the generator was built from.
+	Commit = "unknown"
+)
+
+// GoVersion returns the Go version the generator was built with.
+func GoVersion() string {
+	return runtime.Version()
+}
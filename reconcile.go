@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// queuedRun is a run waited for by one or more callers.
+type queuedRun struct {
+	// namespaces limits the run to these namespaces, nil means all target namespaces.
+	namespaces map[string]struct{}
+	done       chan struct{}
+	errs       map[string]error
+	processed  []string
+	// err is set when the run did not start at all.
+	err error
+}
+
+// complete records the outcome of the run and releases the waiting callers.
+func (r *queuedRun) complete(processed []string, errs map[string]error) {
+	r.processed = processed
+	r.errs = errs
+	close(r.done)
+}
+
+// abort releases the waiting callers of a run which will not start.
+func (r *queuedRun) abort(err error) {
+	r.err = err
+	close(r.done)
+}
+
+// runQueue coalesces run requests. Requests made while a run is queued join that run,
+// requests made while a run is in flight are queued for the next run.
+type runQueue struct {
+	sync.Mutex
+	next   *queuedRun
+	wakeup chan struct{}
+	closed error
+}
+
+func newRunQueue() *runQueue {
+	return &runQueue{
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// enqueue requests a run of the namespaces, all target namespaces when none are given.
+func (q *runQueue) enqueue(namespaces ...string) *queuedRun {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed != nil {
+		run := &queuedRun{done: make(chan struct{})}
+		run.abort(q.closed)
+		return run
+	}
+	if q.next == nil {
+		q.next = &queuedRun{
+			namespaces: map[string]struct{}{},
+			done:       make(chan struct{}),
+		}
+	}
+	switch {
+	case len(namespaces) == 0:
+		q.next.namespaces = nil
+	case q.next.namespaces != nil:
+		for _, ns := range namespaces {
+			q.next.namespaces[ns] = struct{}{}
+		}
+	}
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+	return q.next
+}
+
+// take removes the queued run from the queue, nil when no run is queued.
+func (q *runQueue) take() *queuedRun {
+	q.Lock()
+	defer q.Unlock()
+	run := q.next
+	q.next = nil
+	select {
+	case <-q.wakeup:
+	default:
+	}
+	return run
+}
+
+// close aborts the queued run and any run requested later with the error.
+func (q *runQueue) close(err error) {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = err
+	if q.next != nil {
+		q.next.abort(err)
+		q.next = nil
+	}
+}
+
+type reconcileNamespaceResult struct {
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type reconcileResponse struct {
+	Namespaces []reconcileNamespaceResult `json:"namespaces"`
+}
+
+// reconcileHandler enqueues a run and responds with the per namespace result once the run completed.
+func reconcileHandler(queue *runQueue, logger hclog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		requested := uniqueNamespaces(r.URL.Query()["namespace"])
+		run := queue.enqueue(requested...)
+
+		select {
+		case <-run.done:
+		case <-r.Context().Done():
+			return
+		}
+
+		if run.err != nil {
+			http.Error(w, run.err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		namespaces := requested
+		if len(namespaces) == 0 {
+			namespaces = run.processed
+		}
+		response := &reconcileResponse{
+			Namespaces: []reconcileNamespaceResult{},
+		}
+		statusCode := http.StatusOK
+		for _, ns := range namespaces {
+			result := reconcileNamespaceResult{Namespace: ns, Status: "ok"}
+			if err, ok := run.errs[ns]; ok {
+				result.Status = "failed"
+				result.Error = err.Error()
+				statusCode = http.StatusInternalServerError
+			}
+			response.Namespaces = append(response.Namespaces, result)
+		}
+		sort.Slice(response.Namespaces, func(i, j int) bool {
+			return response.Namespaces[i].Namespace < response.Namespaces[j].Namespace
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Error("Failed writing reconcile response",
+				"reason", err)
+		}
+	})
+}
+
+// uniqueNamespaces returns the namespaces without duplicates, in the order they were given.
+func uniqueNamespaces(namespaces []string) []string {
+	seen := map[string]struct{}{}
+	unique := []string{}
+	for _, ns := range namespaces {
+		if _, ok := seen[ns]; ok {
+			continue
+		}
+		seen[ns] = struct{}{}
+		unique = append(unique, ns)
+	}
+	return unique
+}
+
+// errShuttingDown is reported to callers waiting for a run which will not start.
+var errShuttingDown = fmt.Errorf("generator is shutting down")